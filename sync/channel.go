@@ -1,6 +1,7 @@
 package chansync
 
 import (
+	"context"
 	"time"
 )
 
@@ -27,48 +28,86 @@ const (
 
 /*
 SyncChannel is a light wrapper around a channel intended only for
-synchronization purposes. It cannot be used to transmit data.
+synchronization purposes. It cannot be used to transmit data. SyncChannel is
+the empty struct specialization of DataChannel, which should be used when
+values need to be transmitted.
 */
-type SyncChannel chan struct{}
+type SyncChannel DataChannel[struct{}]
 
 // NewSyncChannel returns an unbuffered SyncChannel
 func NewSyncChannel() SyncChannel {
-	return SyncChannel(make(chan struct{}))
+	return SyncChannel(NewDataChannel[struct{}]())
 }
 
 // NewSyncChannelN returns a SyncChannel with the specified buffer depth
 func NewSyncChannelN(n int) SyncChannel {
-	return SyncChannel(make(chan struct{}, n))
+	return SyncChannel(NewDataChannelN[struct{}](n))
+}
+
+// data returns ch as the DataChannel it specializes.
+func (ch SyncChannel) data() DataChannel[struct{}] {
+	return DataChannel[struct{}](ch)
 }
 
 // Send writes a signal to the channel. If the channel cannot be written to,
 // Send blocks until it can. If the channel is closed while Send is blocking,
 // Send panics.
 func (ch SyncChannel) Send() {
-	ch <- empty
+	ch.data().Send(empty)
+}
+
+// SendContext writes a signal to the channel. If the channel cannot be written
+// to, SendContext blocks until it can or until ctx is done. If ctx is done
+// first, SendContext returns ctx.Err() and no signal is written. If the channel
+// is closed, SendContext panics.
+func (ch SyncChannel) SendContext(ctx context.Context) error {
+	return ch.data().SendContext(ctx, empty)
 }
 
 // TrySend attempts to write a signal to the channel. If the channel cannot be
 // written to, TrySend returns ChannelOpFailure. Otherwise, TrySend returns
 // ChannelOpSuccess. If the channel is closed, TrySend panics.
 func (ch SyncChannel) TrySend() ChannelOpResult {
-	select {
-	case ch <- empty:
-		return ChannelOpSuccess
-	default:
-		return ChannelOpFailure
-	}
+	return ch.data().TrySend(empty)
+}
+
+// TrySendErr is TrySend, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch SyncChannel) TrySendErr() error {
+	return ch.TrySend().Err()
+}
+
+// TimeoutSend attempts to write a signal to the channel, with a timeout. If
+// the channel cannot be written to before the timeout expires, TimeoutSend
+// returns ChannelOpTimeout. Otherwise, TimeoutSend returns ChannelOpSuccess.
+// If the channel is closed, TimeoutSend panics.
+func (ch SyncChannel) TimeoutSend(timeout time.Duration) ChannelOpResult {
+	return ch.data().TimeoutSend(empty, timeout)
+}
+
+// TimeoutSendErr is TimeoutSend, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch SyncChannel) TimeoutSendErr(timeout time.Duration) error {
+	return ch.TimeoutSend(timeout).Err()
 }
 
 // Recv reads a signal from the channel. If the channel cannot be read from,
 // Recv blocks until it can. If the channel is closed, TrySend returns
 // ChannelOpClosed. Otherwise, Recv returns ChannelOpSuccess.
 func (ch SyncChannel) Recv() ChannelOpResult {
-	_, ok := <- ch
-	if ok {
-		return ChannelOpSuccess
-	}
-	return ChannelOpClosed
+	_, r := ch.data().Recv()
+	return r
+}
+
+// RecvContext reads a signal from the channel. If the channel cannot be read
+// from, RecvContext blocks until it can or until ctx is done. If ctx is done
+// first, RecvContext returns ChannelOpTimeout (deadline exceeded) or
+// ChannelOpFailure (canceled) along with ctx.Err(), and no signal is read.
+// Otherwise, RecvContext returns ChannelOpSuccess or ChannelOpClosed and a nil
+// error.
+func (ch SyncChannel) RecvContext(ctx context.Context) (ChannelOpResult, error) {
+	_, r, err := ch.data().RecvContext(ctx)
+	return r, err
 }
 
 // TryRecv attempts to read a signal from the channel. If the channel cannot be
@@ -76,15 +115,14 @@ func (ch SyncChannel) Recv() ChannelOpResult {
 // TryRecv returns ChannelOpClosed. Otherwise, TryRecv returns
 // ChannelOpSuccess.
 func (ch SyncChannel) TryRecv() ChannelOpResult {
-	select {
-	case _, ok := <- ch:
-		if ok {
-			return ChannelOpSuccess
-		}
-		return ChannelOpClosed
-	default:
-		return ChannelOpFailure
-	}
+	_, r := ch.data().TryRecv()
+	return r
+}
+
+// TryRecvErr is TryRecv, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch SyncChannel) TryRecvErr() error {
+	return ch.TryRecv().Err()
 }
 
 // TimeoutRecv attempts to read a signal from the channel, with a timeout. If
@@ -92,15 +130,14 @@ func (ch SyncChannel) TryRecv() ChannelOpResult {
 // returns ChannelOpTimeout. If the channel is closed, TimeoutRecv returns
 // ChannelOpClosed. Otherwise, TimeoutRecv returns ChannelOpSuccess.
 func (ch SyncChannel) TimeoutRecv(timeout time.Duration) ChannelOpResult {
-	select {
-	case _, ok := <- ch:
-		if ok {
-			return ChannelOpSuccess
-		}
-		return ChannelOpClosed
-	case <- Timeout(timeout):
-		return ChannelOpTimeout
-	}
+	_, r := ch.data().TimeoutRecv(timeout)
+	return r
+}
+
+// TimeoutRecvErr is TimeoutRecv, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch SyncChannel) TimeoutRecvErr(timeout time.Duration) error {
+	return ch.TimeoutRecv(timeout).Err()
 }
 
 // Close closes the underlying chan that SyncChannel uses. This will result
@@ -108,5 +145,5 @@ func (ch SyncChannel) TimeoutRecv(timeout time.Duration) ChannelOpResult {
 // any currently blocked receive calls or future recieve calls returning
 // ChannelOpClosed.
 func (ch SyncChannel) Close() {
-	close(ch)
-}
\ No newline at end of file
+	ch.data().Close()
+}
@@ -0,0 +1,58 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// canceledContext returns a context that has been canceled.
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestSyncChannelRecvContext(t *testing.T) {
+	ch := NewSyncChannelN(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if r, err := ch.RecvContext(ctx); r != ChannelOpTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RecvContext() = (%v, %v) after the deadline, want (%v, %v)", r, err, ChannelOpTimeout, context.DeadlineExceeded)
+	}
+	if r, err := ch.RecvContext(canceledContext()); r != ChannelOpFailure || !errors.Is(err, context.Canceled) {
+		t.Errorf("RecvContext() = (%v, %v) when canceled, want (%v, %v)", r, err, ChannelOpFailure, context.Canceled)
+	}
+
+	ch.Send()
+	if r, err := ch.RecvContext(context.Background()); r != ChannelOpSuccess || err != nil {
+		t.Errorf("RecvContext() = (%v, %v) with a signal, want (%v, nil)", r, err, ChannelOpSuccess)
+	}
+	ch.Close()
+	if r, err := ch.RecvContext(context.Background()); r != ChannelOpClosed || err != nil {
+		t.Errorf("RecvContext() = (%v, %v) when closed, want (%v, nil)", r, err, ChannelOpClosed)
+	}
+}
+
+func TestSyncChannelSendContext(t *testing.T) {
+	ch := NewSyncChannelN(1)
+	if err := ch.SendContext(context.Background()); err != nil {
+		t.Fatalf("SendContext() = %v with room in the buffer", err)
+	}
+
+	// the buffer is full, so these give up without writing a signal
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if err := ch.SendContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendContext() = %v after the deadline, want %v", err, context.DeadlineExceeded)
+	}
+	if err := ch.SendContext(canceledContext()); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendContext() = %v when canceled, want %v", err, context.Canceled)
+	}
+	ch.Recv()
+	if r := ch.TryRecv(); r != ChannelOpFailure {
+		t.Errorf("a canceled SendContext wrote a signal: %v", r)
+	}
+}
@@ -0,0 +1,253 @@
+package chansync
+
+import (
+	"context"
+	"time"
+)
+
+// ClosableSyncChannel is a SyncChannel that can be closed safely. Closing a
+// ClosableSyncChannel never causes a panic: Close may be called any number of
+// times, and sending on a closed channel returns ChannelOpClosed. Signals that
+// were sent before the channel was closed can still be received.
+type ClosableSyncChannel interface {
+	// Send writes a signal to the channel. If the channel cannot be written
+	// to, Send blocks until it can. If the channel is closed, Send returns
+	// ChannelOpClosed. Otherwise, Send returns ChannelOpSuccess.
+	Send() ChannelOpResult
+	// SendContext writes a signal to the channel. If the channel cannot be
+	// written to, SendContext blocks until it can or until ctx is done. If
+	// ctx is done first, SendContext returns ChannelOpTimeout (deadline
+	// exceeded) or ChannelOpFailure (canceled) along with ctx.Err(). If the
+	// channel is closed, SendContext returns ChannelOpClosed. Otherwise,
+	// SendContext returns ChannelOpSuccess.
+	SendContext(ctx context.Context) (ChannelOpResult, error)
+	// TrySend attempts to write a signal to the channel. If the channel
+	// cannot be written to, TrySend returns ChannelOpFailure. If the channel
+	// is closed, TrySend returns ChannelOpClosed. Otherwise, TrySend returns
+	// ChannelOpSuccess.
+	TrySend() ChannelOpResult
+	// TrySendErr is TrySend, reporting the result as an error. See
+	// ChannelOpResult.Err.
+	TrySendErr() error
+	// TimeoutSend attempts to write a signal to the channel, with a timeout.
+	// If the channel cannot be written to before the timeout expires,
+	// TimeoutSend returns ChannelOpTimeout. If the channel is closed,
+	// TimeoutSend returns ChannelOpClosed. Otherwise, TimeoutSend returns
+	// ChannelOpSuccess.
+	TimeoutSend(timeout time.Duration) ChannelOpResult
+	// TimeoutSendErr is TimeoutSend, reporting the result as an error. See
+	// ChannelOpResult.Err.
+	TimeoutSendErr(timeout time.Duration) error
+
+	// Recv reads a signal from the channel. If the channel cannot be read
+	// from, Recv blocks until it can. If the channel is closed and no signals
+	// remain, Recv returns ChannelOpClosed. Otherwise, Recv returns
+	// ChannelOpSuccess.
+	Recv() ChannelOpResult
+	// RecvContext reads a signal from the channel. If the channel cannot be
+	// read from, RecvContext blocks until it can or until ctx is done. If ctx
+	// is done first, RecvContext returns ChannelOpTimeout (deadline exceeded)
+	// or ChannelOpFailure (canceled) along with ctx.Err(). If the channel is
+	// closed and no signals remain, RecvContext returns ChannelOpClosed.
+	// Otherwise, RecvContext returns ChannelOpSuccess.
+	RecvContext(ctx context.Context) (ChannelOpResult, error)
+	// TryRecv attempts to read a signal from the channel. If the channel
+	// cannot be read from, TryRecv returns ChannelOpFailure. If the channel
+	// is closed and no signals remain, TryRecv returns ChannelOpClosed.
+	// Otherwise, TryRecv returns ChannelOpSuccess.
+	TryRecv() ChannelOpResult
+	// TryRecvErr is TryRecv, reporting the result as an error. See
+	// ChannelOpResult.Err.
+	TryRecvErr() error
+	// TimeoutRecv attempts to read a signal from the channel, with a
+	// timeout. If the channel cannot be read from before the timeout
+	// expires, TimeoutRecv returns ChannelOpTimeout. If the channel is closed
+	// and no signals remain, TimeoutRecv returns ChannelOpClosed. Otherwise,
+	// TimeoutRecv returns ChannelOpSuccess.
+	TimeoutRecv(timeout time.Duration) ChannelOpResult
+	// TimeoutRecvErr is TimeoutRecv, reporting the result as an error. See
+	// ChannelOpResult.Err.
+	TimeoutRecvErr(timeout time.Duration) error
+
+	// Close closes the channel. Any currently blocked or future send calls
+	// return ChannelOpClosed, and once any remaining signals have been
+	// received, any currently blocked or future receive calls return
+	// ChannelOpClosed. Close is idempotent.
+	Close()
+	// Closed returns whether or not the channel has been closed.
+	Closed() bool
+	// Done returns a channel that is closed when the channel is closed.
+	Done() <-chan struct{}
+}
+
+type closableSyncChannel struct {
+	ch SyncChannel
+	done SyncChannel
+	once SyncChannel
+}
+
+// signaled is a closed channel, used as the cancel channel of operations that
+// must not block
+var signaled = func() SyncChannel {
+	ch := NewSyncChannel()
+	ch.Close()
+	return ch
+}()
+
+// NewClosableSyncChannel returns an unbuffered ClosableSyncChannel
+func NewClosableSyncChannel() ClosableSyncChannel {
+	return NewClosableSyncChannelN(0)
+}
+
+// NewClosableSyncChannelN returns a ClosableSyncChannel with the specified
+// buffer depth
+func NewClosableSyncChannelN(n int) ClosableSyncChannel {
+	return &closableSyncChannel{
+		ch: NewSyncChannelN(n),
+		done: NewSyncChannel(),
+		once: NewSyncChannelN(1),
+	}
+}
+
+// send writes a signal to the channel. If cancel fires first, send returns
+// ChannelOpFailure.
+func (c *closableSyncChannel) send(cancel <-chan struct{}) ChannelOpResult {
+	// the underlying channel is never closed, so closure must be checked
+	// explicitly
+	if c.Closed() {
+		return ChannelOpClosed
+	}
+
+	select {
+	case c.ch <- empty:
+		return ChannelOpSuccess
+	default:
+	}
+
+	select {
+	case c.ch <- empty:
+		return ChannelOpSuccess
+	case <- c.done:
+		return ChannelOpClosed
+	case <- cancel:
+		return ChannelOpFailure
+	}
+}
+
+// recv reads a signal from the channel. If cancel fires first, recv returns
+// ChannelOpFailure.
+func (c *closableSyncChannel) recv(cancel <-chan struct{}) ChannelOpResult {
+	// buffered signals take precedence over closure and cancel
+	select {
+	case <- c.ch:
+		return ChannelOpSuccess
+	default:
+	}
+
+	if c.Closed() {
+		return c.drain()
+	}
+
+	select {
+	case <- c.ch:
+		return ChannelOpSuccess
+	case <- c.done:
+		return c.drain()
+	case <- cancel:
+		return ChannelOpFailure
+	}
+}
+
+// drain reads a signal that was sent before the channel was closed, if there
+// is one.
+func (c *closableSyncChannel) drain() ChannelOpResult {
+	if c.ch.TryRecv() == ChannelOpSuccess {
+		return ChannelOpSuccess
+	}
+	return ChannelOpClosed
+}
+
+func (c *closableSyncChannel) Send() ChannelOpResult {
+	return c.send(nil)
+}
+
+func (c *closableSyncChannel) SendContext(ctx context.Context) (ChannelOpResult, error) {
+	if r := c.send(ctx.Done()); r != ChannelOpFailure {
+		return r, nil
+	}
+	return contextResult(ctx)
+}
+
+func (c *closableSyncChannel) TrySend() ChannelOpResult {
+	return c.send(signaled)
+}
+
+func (c *closableSyncChannel) TrySendErr() error {
+	return c.TrySend().Err()
+}
+
+func (c *closableSyncChannel) TimeoutSend(timeout time.Duration) ChannelOpResult {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	if r := c.send(t.C()); r != ChannelOpFailure {
+		return r
+	}
+	return ChannelOpTimeout
+}
+
+func (c *closableSyncChannel) TimeoutSendErr(timeout time.Duration) error {
+	return c.TimeoutSend(timeout).Err()
+}
+
+func (c *closableSyncChannel) Recv() ChannelOpResult {
+	return c.recv(nil)
+}
+
+func (c *closableSyncChannel) RecvContext(ctx context.Context) (ChannelOpResult, error) {
+	if r := c.recv(ctx.Done()); r != ChannelOpFailure {
+		return r, nil
+	}
+	return contextResult(ctx)
+}
+
+func (c *closableSyncChannel) TryRecv() ChannelOpResult {
+	return c.recv(signaled)
+}
+
+func (c *closableSyncChannel) TryRecvErr() error {
+	return c.TryRecv().Err()
+}
+
+func (c *closableSyncChannel) TimeoutRecv(timeout time.Duration) ChannelOpResult {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	if r := c.recv(t.C()); r != ChannelOpFailure {
+		return r
+	}
+	return ChannelOpTimeout
+}
+
+func (c *closableSyncChannel) TimeoutRecvErr(timeout time.Duration) error {
+	return c.TimeoutRecv(timeout).Err()
+}
+
+func (c *closableSyncChannel) Close() {
+	if c.once.TrySend() == ChannelOpSuccess {
+		c.done.Close()
+		return
+	}
+
+	// another call is closing the channel; wait for it to finish so that
+	// Closed is true once Close returns
+	c.done.Recv()
+}
+
+func (c *closableSyncChannel) Closed() bool {
+	return c.done.TryRecv() == ChannelOpClosed
+}
+
+func (c *closableSyncChannel) Done() <-chan struct{} {
+	return c.done
+}
@@ -0,0 +1,127 @@
+package chansync
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClosableCloseIdempotent(t *testing.T) {
+	c := NewClosableSyncChannel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Close()
+
+			// Close returns only once the channel is closed
+			if !c.Closed() {
+				t.Error("Closed() is false after Close returned")
+			}
+		}()
+	}
+	wg.Wait()
+	c.Close()
+}
+
+func TestClosableSendClosed(t *testing.T) {
+	c := NewClosableSyncChannelN(1)
+	c.Close()
+
+	if r := c.Send(); r != ChannelOpClosed {
+		t.Errorf("Send() = %v, want %v", r, ChannelOpClosed)
+	}
+	if r := c.TrySend(); r != ChannelOpClosed {
+		t.Errorf("TrySend() = %v, want %v", r, ChannelOpClosed)
+	}
+	if err := c.TrySendErr(); !errors.Is(err, ErrClosed) {
+		t.Errorf("TrySendErr() = %v, want %v", err, ErrClosed)
+	}
+	if r := c.TimeoutSend(time.Hour); r != ChannelOpClosed {
+		t.Errorf("TimeoutSend() = %v, want %v", r, ChannelOpClosed)
+	}
+}
+
+func TestClosableCloseUnblocksSend(t *testing.T) {
+	c := NewClosableSyncChannel()
+
+	sent := make(chan ChannelOpResult)
+	go func() { sent <- c.Send() }()
+	go func() { sent <- c.TimeoutSend(time.Hour) }()
+
+	c.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case r := <- sent:
+			if r != ChannelOpClosed {
+				t.Errorf("got %v, want %v", r, ChannelOpClosed)
+			}
+		case <- time.After(5 * time.Second):
+			t.Fatal("a blocked send did not return after Close")
+		}
+	}
+}
+
+func TestClosableDrainsAfterClose(t *testing.T) {
+	c := NewClosableSyncChannelN(2)
+	c.Send()
+	c.Send()
+	c.Close()
+
+	if r := c.TryRecv(); r != ChannelOpSuccess {
+		t.Errorf("TryRecv() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if r := c.Recv(); r != ChannelOpSuccess {
+		t.Errorf("Recv() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if r := c.Recv(); r != ChannelOpClosed {
+		t.Errorf("Recv() = %v once drained, want %v", r, ChannelOpClosed)
+	}
+	if r := c.TimeoutRecv(time.Hour); r != ChannelOpClosed {
+		t.Errorf("TimeoutRecv() = %v once drained, want %v", r, ChannelOpClosed)
+	}
+}
+
+func TestClosableClosedAndDone(t *testing.T) {
+	c := NewClosableSyncChannel()
+	if c.Closed() {
+		t.Fatal("Closed() is true before Close")
+	}
+	select {
+	case <- c.Done():
+		t.Fatal("Done() is closed before Close")
+	default:
+	}
+
+	c.Close()
+	if !c.Closed() {
+		t.Fatal("Closed() is false after Close")
+	}
+	select {
+	case <- c.Done():
+	default:
+		t.Fatal("Done() is not closed after Close")
+	}
+}
+
+func TestClosableOpen(t *testing.T) {
+	c := NewClosableSyncChannelN(1)
+	if r := c.TryRecv(); r != ChannelOpFailure {
+		t.Errorf("TryRecv() = %v on an empty channel, want %v", r, ChannelOpFailure)
+	}
+	if r := c.TrySend(); r != ChannelOpSuccess {
+		t.Errorf("TrySend() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if r := c.TimeoutSend(10 * time.Millisecond); r != ChannelOpTimeout {
+		t.Errorf("TimeoutSend() = %v on a full channel, want %v", r, ChannelOpTimeout)
+	}
+	if r := c.Recv(); r != ChannelOpSuccess {
+		t.Errorf("Recv() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if r := c.TimeoutRecv(10 * time.Millisecond); r != ChannelOpTimeout {
+		t.Errorf("TimeoutRecv() = %v on an empty channel, want %v", r, ChannelOpTimeout)
+	}
+}
@@ -0,0 +1,150 @@
+package chansync
+
+import (
+	"context"
+	"reflect"
+)
+
+// Merge returns a SyncChannel that receives a signal whenever any of the
+// specified channels is signaled. Signals that arrive while an earlier signal
+// has not yet been received from the returned channel are redundant, and are
+// merged into it. Merge uses a single helper goroutine, which closes the
+// returned channel and exits once every input has been closed, dropping a
+// signal that has not been received by then.
+func Merge(chs ...SyncChannel) SyncChannel {
+	out := NewSyncChannel()
+
+	// the first case sends the pending signal, if there is one; the rest
+	// are the inputs
+	sel := append([]reflect.SelectCase{{Dir: reflect.SelectSend, Send: reflect.ValueOf(empty)}}, recvCases(chs)...)
+
+	go func() {
+		defer out.Close()
+
+		for len(sel) > 1 {
+			i, _, ok := reflect.Select(sel)
+			switch {
+			case i == 0:
+				// the signal was delivered
+				sel[0].Chan = reflect.Value{}
+			case !ok:
+				// stop selecting on closed inputs
+				sel = append(sel[:i], sel[i+1:]...)
+			default:
+				sel[0].Chan = reflect.ValueOf(out)
+			}
+		}
+	}()
+
+	return out
+}
+
+// Any blocks until any of the specified channels is signaled or closed. Any
+// returns the index of that channel along with ChannelOpSuccess, or
+// ChannelOpClosed if the channel was closed.
+func Any(chs ...SyncChannel) (int, ChannelOpResult) {
+	i, r, _ := AnyContext(context.Background(), chs...)
+	return i, r
+}
+
+// AnyContext is Any, but gives up once ctx is done. If ctx is done first,
+// AnyContext returns -1, ChannelOpTimeout (deadline exceeded) or
+// ChannelOpFailure (canceled), and ctx.Err(), and no signal is read.
+func AnyContext(ctx context.Context, chs ...SyncChannel) (int, ChannelOpResult, error) {
+	sel := append(recvCases(chs), reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+
+	i, _, ok := reflect.Select(sel)
+	if i == len(chs) {
+		r, err := contextResult(ctx)
+		return -1, r, err
+	}
+	if !ok {
+		return i, ChannelOpClosed, nil
+	}
+	return i, ChannelOpSuccess, nil
+}
+
+// All blocks until every one of the specified channels has been signaled once.
+// All returns the indices of the channels in the order they were signaled,
+// along with ChannelOpSuccess. If a channel is closed before it is signaled,
+// All stops waiting and returns the indices signaled so far followed by the
+// index of the closed channel, along with ChannelOpClosed. Signals that were
+// read before All stopped waiting are not returned to their channels.
+func All(chs ...SyncChannel) ([]int, ChannelOpResult) {
+	order, r, _ := AllContext(context.Background(), chs...)
+	return order, r
+}
+
+// AllContext is All, but gives up once ctx is done. If ctx is done first,
+// AllContext returns the indices signaled so far, ChannelOpTimeout (deadline
+// exceeded) or ChannelOpFailure (canceled), and ctx.Err().
+func AllContext(ctx context.Context, chs ...SyncChannel) ([]int, ChannelOpResult, error) {
+	sel := append(recvCases(chs), reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	order := make([]int, 0, len(chs))
+
+	for len(order) < len(chs) {
+		i, _, ok := reflect.Select(sel)
+		if i == len(chs) {
+			r, err := contextResult(ctx)
+			return order, r, err
+		}
+
+		order = append(order, i)
+		if !ok {
+			return order, ChannelOpClosed, nil
+		}
+
+		// a nil channel is never ready, so this channel drops out
+		sel[i].Chan = reflect.ValueOf(SyncChannel(nil))
+	}
+
+	return order, ChannelOpSuccess, nil
+}
+
+// Broadcast relays every signal received from src to each of dsts. Signals
+// that arrive while a destination has not yet received an earlier signal are
+// redundant, and are merged into it for that destination, so a destination
+// that is not being received from never holds up the others. Broadcast takes
+// ownership of dsts: once src is closed, the helper goroutine closes every
+// destination, dropping any signal it has not received, and exits.
+func Broadcast(src SyncChannel, dsts ...SyncChannel) {
+	// the first case is the source; the rest send the pending signal of
+	// each destination, if there is one
+	sel := make([]reflect.SelectCase, len(dsts)+1)
+	sel[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(src)}
+	for i := range dsts {
+		sel[i+1] = reflect.SelectCase{Dir: reflect.SelectSend, Send: reflect.ValueOf(empty)}
+	}
+
+	go func() {
+		defer func() {
+			for _, dst := range dsts {
+				dst.Close()
+			}
+		}()
+
+		for {
+			i, _, ok := reflect.Select(sel)
+			switch {
+			case i > 0:
+				// the signal was delivered to dsts[i-1]
+				sel[i].Chan = reflect.Value{}
+			case !ok:
+				return
+			default:
+				for i, dst := range dsts {
+					sel[i+1].Chan = reflect.ValueOf(dst)
+				}
+			}
+		}
+	}()
+}
+
+// recvCases returns a receive select case for each channel.
+func recvCases(chs []SyncChannel) []reflect.SelectCase {
+	sel := make([]reflect.SelectCase, len(chs), len(chs)+1)
+	for i, ch := range chs {
+		sel[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+	}
+	return sel
+}
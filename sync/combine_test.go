@@ -0,0 +1,82 @@
+package chansync
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMergeCoalesces(t *testing.T) {
+	a, b := NewSyncChannelN(1), NewSyncChannelN(1)
+	out := Merge(a, b)
+
+	a.Send()
+	b.Send()
+	if r := out.TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("Recv() = %v, want %v", r, ChannelOpSuccess)
+	}
+
+	a.Close()
+	b.Close()
+	if r := out.TimeoutRecv(5 * time.Second); r != ChannelOpClosed && r != ChannelOpSuccess {
+		t.Fatalf("Recv() = %v after closing the inputs", r)
+	}
+}
+
+func TestMergeNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// nothing ever receives from the merged channel, so the helper must not
+	// block sending to it once the inputs are closed
+	a, b := NewSyncChannelN(1), NewSyncChannelN(1)
+	out := Merge(a, b)
+	a.Send()
+	b.Send()
+	a.Close()
+	b.Close()
+
+	waitUntil(t, "the helper goroutine exits", func() bool { return runtime.NumGoroutine() <= before })
+	waitUntil(t, "the merged channel is closed", func() bool {
+		select {
+		case _, ok := <- out:
+			return !ok
+		default:
+			return false
+		}
+	})
+}
+
+func TestBroadcastSlowDestination(t *testing.T) {
+	src := NewSyncChannel()
+	slow, fast := NewSyncChannel(), NewSyncChannel()
+	Broadcast(src, slow, fast)
+
+	// slow is never received from, and must not hold up fast
+	for i := 0; i < 3; i++ {
+		src.Send()
+		if r := fast.TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+			t.Fatalf("Recv() = %v, want %v", r, ChannelOpSuccess)
+		}
+	}
+	src.Close()
+}
+
+func TestBroadcastNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	src := NewSyncChannel()
+	dst := NewSyncChannel()
+	Broadcast(src, dst)
+	src.Send()
+	src.Close()
+
+	waitUntil(t, "the helper goroutine exits", func() bool { return runtime.NumGoroutine() <= before })
+	waitUntil(t, "the destination is closed", func() bool {
+		select {
+		case _, ok := <- dst:
+			return !ok
+		default:
+			return false
+		}
+	})
+}
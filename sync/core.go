@@ -6,6 +6,7 @@ stdlib's sync package, but they are easier to understand.
 package chansync
 
 import (
+	"context"
 	"time"
 )
 
@@ -17,16 +18,21 @@ type Destroyable interface {
 
 // Timeout returns a SyncChannel that will be Recv-able after the specified
 // duration. The returned channel can be used in a select block to timeout
-// blocking channel operations.
+// blocking channel operations. Timeout cannot be canceled; use NewTimer if the
+// timeout may be abandoned before it expires.
 func Timeout(t time.Duration) SyncChannel {
-	ch := NewSyncChannelN(1)
-
-	go func() {
-		time.Sleep(t)
-		ch.Send()
-	}()
+	return NewTimer(t).C()
+}
 
-	return ch
+// contextResult returns the result and error that correspond to a done
+// context. An expired deadline is reported as ChannelOpTimeout, any other
+// cancellation as ChannelOpFailure.
+func contextResult(ctx context.Context) (ChannelOpResult, error) {
+	err := ctx.Err()
+	if err == context.DeadlineExceeded {
+		return ChannelOpTimeout, err
+	}
+	return ChannelOpFailure, err
 }
 
 //go:generate go run gen/main.go atomic chansync Int int atomic.int.go
@@ -45,5 +51,5 @@ func Decrement(a AtomicInt) bool {
 // operation was successful.
 func Increment(a AtomicInt) bool {
 	v := a.Read()
-	return a.Write(v, v - 1)
+	return a.Write(v, v + 1)
 }
\ No newline at end of file
@@ -0,0 +1,24 @@
+package chansync
+
+import "testing"
+
+func TestIncrementDecrement(t *testing.T) {
+	a := NewAtomicInt(5)
+
+	if !Increment(a) {
+		t.Fatal("Increment failed without contention")
+	}
+	if got := a.Read(); got != 6 {
+		t.Fatalf("Read() = %d after Increment, want 6", got)
+	}
+
+	if !Decrement(a) {
+		t.Fatal("Decrement failed without contention")
+	}
+	if !Decrement(a) {
+		t.Fatal("Decrement failed without contention")
+	}
+	if got := a.Read(); got != 4 {
+		t.Fatalf("Read() = %d after two Decrements, want 4", got)
+	}
+}
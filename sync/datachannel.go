@@ -0,0 +1,175 @@
+package chansync
+
+import (
+	"context"
+	"time"
+)
+
+/*
+DataChannel is a light wrapper around a channel that transmits values of type
+T. It provides the same Try, Timeout and Context variants as SyncChannel, and
+reports the outcome of each operation as a ChannelOpResult.
+*/
+type DataChannel[T any] chan T
+
+// NewDataChannel returns an unbuffered DataChannel
+func NewDataChannel[T any]() DataChannel[T] {
+	return DataChannel[T](make(chan T))
+}
+
+// NewDataChannelN returns a DataChannel with the specified buffer depth
+func NewDataChannelN[T any](n int) DataChannel[T] {
+	return DataChannel[T](make(chan T, n))
+}
+
+// Send writes v to the channel. If the channel cannot be written to, Send
+// blocks until it can. If the channel is closed while Send is blocking, Send
+// panics.
+func (ch DataChannel[T]) Send(v T) {
+	ch <- v
+}
+
+// SendContext writes v to the channel. If the channel cannot be written to,
+// SendContext blocks until it can or until ctx is done. If ctx is done first,
+// SendContext returns ctx.Err() and v is not written. If the channel is
+// closed, SendContext panics.
+func (ch DataChannel[T]) SendContext(ctx context.Context, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	case <- ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySend attempts to write v to the channel. If the channel cannot be written
+// to, TrySend returns ChannelOpFailure. Otherwise, TrySend returns
+// ChannelOpSuccess. If the channel is closed, TrySend panics.
+func (ch DataChannel[T]) TrySend(v T) ChannelOpResult {
+	select {
+	case ch <- v:
+		return ChannelOpSuccess
+	default:
+		return ChannelOpFailure
+	}
+}
+
+// TrySendErr is TrySend, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch DataChannel[T]) TrySendErr(v T) error {
+	return ch.TrySend(v).Err()
+}
+
+// TimeoutSend attempts to write v to the channel, with a timeout. If the
+// channel cannot be written to before the timeout expires, TimeoutSend returns
+// ChannelOpTimeout. Otherwise, TimeoutSend returns ChannelOpSuccess. If the
+// channel is closed, TimeoutSend panics.
+func (ch DataChannel[T]) TimeoutSend(v T, timeout time.Duration) ChannelOpResult {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case ch <- v:
+		return ChannelOpSuccess
+	case <- t.C():
+		return ChannelOpTimeout
+	}
+}
+
+// TimeoutSendErr is TimeoutSend, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch DataChannel[T]) TimeoutSendErr(v T, timeout time.Duration) error {
+	return ch.TimeoutSend(v, timeout).Err()
+}
+
+// Recv reads a value from the channel. If the channel cannot be read from,
+// Recv blocks until it can. If the channel is closed, Recv returns the zero
+// value and ChannelOpClosed. Otherwise, Recv returns the value read and
+// ChannelOpSuccess.
+func (ch DataChannel[T]) Recv() (T, ChannelOpResult) {
+	v, ok := <- ch
+	if ok {
+		return v, ChannelOpSuccess
+	}
+	return v, ChannelOpClosed
+}
+
+// RecvContext reads a value from the channel. If the channel cannot be read
+// from, RecvContext blocks until it can or until ctx is done. If ctx is done
+// first, RecvContext returns the zero value, ChannelOpTimeout (deadline
+// exceeded) or ChannelOpFailure (canceled), and ctx.Err(). Otherwise,
+// RecvContext returns the value read, ChannelOpSuccess or ChannelOpClosed, and
+// a nil error.
+func (ch DataChannel[T]) RecvContext(ctx context.Context) (T, ChannelOpResult, error) {
+	select {
+	case v, ok := <- ch:
+		if ok {
+			return v, ChannelOpSuccess, nil
+		}
+		return v, ChannelOpClosed, nil
+	case <- ctx.Done():
+		var zero T
+		r, err := contextResult(ctx)
+		return zero, r, err
+	}
+}
+
+// TryRecv attempts to read a value from the channel. If the channel cannot be
+// read from TryRecv returns ChannelOpFailure. If the channel is closed,
+// TryRecv returns ChannelOpClosed. Otherwise, TryRecv returns the value read
+// and ChannelOpSuccess.
+func (ch DataChannel[T]) TryRecv() (T, ChannelOpResult) {
+	select {
+	case v, ok := <- ch:
+		if ok {
+			return v, ChannelOpSuccess
+		}
+		return v, ChannelOpClosed
+	default:
+		var zero T
+		return zero, ChannelOpFailure
+	}
+}
+
+// TryRecvErr is TryRecv, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch DataChannel[T]) TryRecvErr() (T, error) {
+	v, r := ch.TryRecv()
+	return v, r.Err()
+}
+
+// TimeoutRecv attempts to read a value from the channel, with a timeout. If
+// the channel cannot be read from before the timeout expires, TimeoutRecv
+// returns ChannelOpTimeout. If the channel is closed, TimeoutRecv returns
+// ChannelOpClosed. Otherwise, TimeoutRecv returns the value read and
+// ChannelOpSuccess.
+func (ch DataChannel[T]) TimeoutRecv(timeout time.Duration) (T, ChannelOpResult) {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case v, ok := <- ch:
+		if ok {
+			return v, ChannelOpSuccess
+		}
+		return v, ChannelOpClosed
+	case <- t.C():
+		var zero T
+		return zero, ChannelOpTimeout
+	}
+}
+
+// TimeoutRecvErr is TimeoutRecv, reporting the result as an error. See
+// ChannelOpResult.Err.
+func (ch DataChannel[T]) TimeoutRecvErr(timeout time.Duration) (T, error) {
+	v, r := ch.TimeoutRecv(timeout)
+	return v, r.Err()
+}
+
+// Close closes the underlying chan that DataChannel uses. This will result in
+// any currently blocked send calls or future send calls panicing, and any
+// currently blocked receive calls or future recieve calls returning
+// ChannelOpClosed.
+func (ch DataChannel[T]) Close() {
+	close(ch)
+}
@@ -0,0 +1,141 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDataChannelRoundTrip(t *testing.T) {
+	ch := NewDataChannel[string]()
+	go ch.Send("hello")
+	if v, r := ch.Recv(); r != ChannelOpSuccess || v != "hello" {
+		t.Fatalf("Recv() = (%q, %v), want (%q, %v)", v, r, "hello", ChannelOpSuccess)
+	}
+
+	buf := NewDataChannelN[int](3)
+	for i := 1; i <= 3; i++ {
+		buf.Send(i)
+	}
+	for i := 1; i <= 3; i++ {
+		if v, _ := buf.Recv(); v != i {
+			t.Fatalf("Recv() = %d, want %d", v, i)
+		}
+	}
+}
+
+func TestDataChannelTry(t *testing.T) {
+	ch := NewDataChannelN[int](1)
+	if v, r := ch.TryRecv(); r != ChannelOpFailure || v != 0 {
+		t.Errorf("TryRecv() = (%d, %v) on an empty channel, want (0, %v)", v, r, ChannelOpFailure)
+	}
+	if _, err := ch.TryRecvErr(); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TryRecvErr() = %v on an empty channel, want %v", err, ErrWouldBlock)
+	}
+	if r := ch.TrySend(7); r != ChannelOpSuccess {
+		t.Errorf("TrySend() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if err := ch.TrySendErr(8); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TrySendErr() = %v on a full channel, want %v", err, ErrWouldBlock)
+	}
+	if v, r := ch.TryRecv(); r != ChannelOpSuccess || v != 7 {
+		t.Errorf("TryRecv() = (%d, %v), want (7, %v)", v, r, ChannelOpSuccess)
+	}
+}
+
+func TestDataChannelTimeout(t *testing.T) {
+	ch := NewDataChannelN[int](1)
+	if v, r := ch.TimeoutRecv(10 * time.Millisecond); r != ChannelOpTimeout || v != 0 {
+		t.Errorf("TimeoutRecv() = (%d, %v) on an empty channel, want (0, %v)", v, r, ChannelOpTimeout)
+	}
+	if r := ch.TimeoutSend(7, time.Second); r != ChannelOpSuccess {
+		t.Errorf("TimeoutSend() = %v, want %v", r, ChannelOpSuccess)
+	}
+	if err := ch.TimeoutSendErr(8, 10 * time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("TimeoutSendErr() = %v on a full channel, want %v", err, ErrTimeout)
+	}
+	if v, err := ch.TimeoutRecvErr(time.Second); err != nil || v != 7 {
+		t.Errorf("TimeoutRecvErr() = (%d, %v), want (7, nil)", v, err)
+	}
+}
+
+func TestDataChannelContext(t *testing.T) {
+	ch := NewDataChannelN[int](1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if v, r, err := ch.RecvContext(ctx); r != ChannelOpTimeout || v != 0 || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RecvContext() = (%d, %v, %v) after the deadline, want (0, %v, %v)", v, r, err, ChannelOpTimeout, context.DeadlineExceeded)
+	}
+	if _, r, err := ch.RecvContext(canceledContext()); r != ChannelOpFailure || !errors.Is(err, context.Canceled) {
+		t.Errorf("RecvContext() = (%v, %v) when canceled, want (%v, %v)", r, err, ChannelOpFailure, context.Canceled)
+	}
+
+	if err := ch.SendContext(context.Background(), 7); err != nil {
+		t.Fatalf("SendContext() = %v with room in the buffer", err)
+	}
+	if err := ch.SendContext(canceledContext(), 8); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendContext() = %v on a full channel when canceled, want %v", err, context.Canceled)
+	}
+	if v, r, err := ch.RecvContext(context.Background()); r != ChannelOpSuccess || v != 7 || err != nil {
+		t.Errorf("RecvContext() = (%d, %v, %v), want (7, %v, nil)", v, r, err, ChannelOpSuccess)
+	}
+}
+
+func TestDataChannelClosed(t *testing.T) {
+	ch := NewDataChannelN[int](1)
+	ch.Send(7)
+	ch.Close()
+
+	// a buffered value is still received
+	if v, r := ch.Recv(); r != ChannelOpSuccess || v != 7 {
+		t.Errorf("Recv() = (%d, %v), want (7, %v)", v, r, ChannelOpSuccess)
+	}
+	if v, r := ch.Recv(); r != ChannelOpClosed || v != 0 {
+		t.Errorf("Recv() = (%d, %v) once drained, want (0, %v)", v, r, ChannelOpClosed)
+	}
+	if _, r := ch.TryRecv(); r != ChannelOpClosed {
+		t.Errorf("TryRecv() = %v, want %v", r, ChannelOpClosed)
+	}
+	if _, r := ch.TimeoutRecv(time.Hour); r != ChannelOpClosed {
+		t.Errorf("TimeoutRecv() = %v, want %v", r, ChannelOpClosed)
+	}
+	if _, r, err := ch.RecvContext(context.Background()); r != ChannelOpClosed || err != nil {
+		t.Errorf("RecvContext() = (%v, %v), want (%v, nil)", r, err, ChannelOpClosed)
+	}
+	if _, err := ch.TryRecvErr(); !errors.Is(err, ErrClosed) {
+		t.Errorf("TryRecvErr() = %v, want %v", err, ErrClosed)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("TrySend on a closed channel did not panic")
+		}
+	}()
+	ch.TrySend(8)
+}
+
+func TestSyncChannelIsDataChannel(t *testing.T) {
+	// SyncChannel delegates to the DataChannel it specializes, so the two
+	// views share one channel
+	ch := NewSyncChannelN(1)
+	data := DataChannel[struct{}](ch)
+
+	ch.Send()
+	if _, r := data.TryRecv(); r != ChannelOpSuccess {
+		t.Errorf("the DataChannel did not receive the SyncChannel's signal: %v", r)
+	}
+	data.Send(struct{}{})
+	if r := ch.TimeoutRecv(time.Second); r != ChannelOpSuccess {
+		t.Errorf("the SyncChannel did not receive the DataChannel's value: %v", r)
+	}
+	if r := ch.TimeoutRecv(10 * time.Millisecond); r != ChannelOpTimeout {
+		t.Errorf("TimeoutRecv() = %v on an empty channel, want %v", r, ChannelOpTimeout)
+	}
+
+	ch.Close()
+	if _, r := data.Recv(); r != ChannelOpClosed {
+		t.Errorf("the DataChannel is not closed by SyncChannel.Close: %v", r)
+	}
+}
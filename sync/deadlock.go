@@ -0,0 +1,210 @@
+package chansync
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// DeadlockError describes a set of goroutines that are blocked on Locks,
+// ReadWriteLocks or Semaphores held by each other. Waits lists every
+// deadlocked goroutine reachable from the goroutine whose wait completed the
+// deadlock, starting with that goroutine.
+type DeadlockError struct {
+	Waits []DeadlockWait
+}
+
+// DeadlockWait describes a goroutine that is blocked acquiring a primitive.
+type DeadlockWait struct {
+	// Goroutine is the ID of the blocked goroutine.
+	Goroutine uint64
+	// Primitive describes the primitive being acquired.
+	Primitive string
+	// Stack is the stack trace of the blocked acquisition.
+	Stack []byte
+	// Holders lists the acquisitions that hold the primitive.
+	Holders []DeadlockHold
+}
+
+// DeadlockHold describes an acquisition that is held.
+type DeadlockHold struct {
+	// Goroutine is the ID of the goroutine that made the acquisition.
+	Goroutine uint64
+	// Stack is the stack trace of the acquisition.
+	Stack []byte
+}
+
+func (e *DeadlockError) Error() string {
+	var b strings.Builder
+	b.WriteString("chansync: deadlock detected")
+	for _, w := range e.Waits {
+		fmt.Fprintf(&b, "\n\ngoroutine %d is waiting to acquire %s at:\n%s", w.Goroutine, w.Primitive, w.Stack)
+		for _, h := range w.Holders {
+			fmt.Fprintf(&b, "\n\twhich is held by goroutine %d, acquired at:\n%s", h.Goroutine, h.Stack)
+		}
+	}
+	return b.String()
+}
+
+var deadlockHandler atomic.Pointer[func(*DeadlockError)]
+
+// EnableDeadlockDetection enables the deadlock detector. While it is enabled,
+// every acquisition of a Lock, ReadWriteLock or Semaphore records which
+// goroutine holds or waits for which primitive, and each time a goroutine
+// blocks the detector checks whether it, and every goroutine it transitively
+// waits for, can no longer make progress. When a deadlock is found, h is called
+// with the details from the goroutine that completed it; that goroutine remains
+// blocked once h returns. If h is nil, the goroutine panics with the
+// *DeadlockError instead.
+//
+// Detection is expensive, because every acquisition records a stack trace,
+// and is intended for debugging and testing. Acquisitions made before the
+// detector is enabled are not known to it. The detector assumes that an
+// acquisition is released by the goroutine that made it, so a design that hands
+// held locks between goroutines can be reported as deadlocked.
+func EnableDeadlockDetection(h func(*DeadlockError)) {
+	if h == nil {
+		h = func(err *DeadlockError) { panic(err) }
+	}
+	deadlockHandler.Store(&h)
+}
+
+// DisableDeadlockDetection disables the deadlock detector.
+func DisableDeadlockDetection() {
+	deadlockHandler.Store(nil)
+}
+
+// detector maintains the wait-for graph
+var detector = &waitGraph{
+	mu: newMutex(),
+	waits: map[uint64]*waitRecord{},
+	holds: map[any]map[*holdRecord]struct{}{},
+}
+
+type waitGraph struct {
+	// mu guards the fields below
+	mu mutex
+	waits map[uint64]*waitRecord
+	holds map[any]map[*holdRecord]struct{}
+}
+
+func (g *waitGraph) wait(w *waitRecord) {
+	h := deadlockHandler.Load()
+	if h == nil {
+		return
+	}
+
+	g.mu.lock()
+	g.waits[w.gid] = w
+	err := g.deadlock(w.gid)
+	g.mu.unlock()
+
+	if err != nil {
+		(*h)(err)
+	}
+}
+
+func (g *waitGraph) unwait(w *waitRecord) {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	if g.waits[w.gid] == w {
+		delete(g.waits, w.gid)
+	}
+}
+
+func (g *waitGraph) hold(h *holdRecord) {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	holds := g.holds[h.prim]
+	if holds == nil {
+		holds = map[*holdRecord]struct{}{}
+		g.holds[h.prim] = holds
+	}
+	holds[h] = struct{}{}
+}
+
+func (g *waitGraph) unhold(h *holdRecord) {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	g.removeHold(h)
+}
+
+func (g *waitGraph) removeHold(h *holdRecord) {
+	holds := g.holds[h.prim]
+	delete(holds, h)
+	if len(holds) == 0 {
+		delete(g.holds, h.prim)
+	}
+}
+
+// deadlock returns a DeadlockError if the goroutine gid is deadlocked, or nil
+// otherwise. A waiting goroutine can make progress if the primitive it waits
+// for is not held, or if any goroutine holding it, other than itself, can
+// make progress. A goroutine waiting on a Semaphore can also make progress if
+// nobody else holds any of its resources. Goroutines that are not waiting can
+// always make progress.
+func (g *waitGraph) deadlock(gid uint64) *DeadlockError {
+	progress := map[uint64]bool{}
+	for changed := true; changed; {
+		changed = false
+		for wid, w := range g.waits {
+			if progress[wid] {
+				continue
+			}
+
+			holds := g.holds[w.prim]
+			others, ok := 0, false
+			for h := range holds {
+				if h.gid == wid {
+					continue
+				}
+				others++
+				if _, waiting := g.waits[h.gid]; !waiting || progress[h.gid] {
+					ok = true
+					break
+				}
+			}
+			if others == 0 {
+				// a goroutine cannot release a lock it is waiting on, but
+				// semaphore resources it holds do not stop others from
+				// releasing theirs
+				_, sem := w.prim.(*semaphore)
+				ok = len(holds) == 0 || sem
+			}
+
+			if ok {
+				progress[wid] = true
+				changed = true
+			}
+		}
+	}
+
+	if progress[gid] {
+		return nil
+	}
+
+	// report every deadlocked goroutine reachable from gid
+	err := &DeadlockError{}
+	seen := map[uint64]bool{}
+	queue := []uint64{gid}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		w := g.waits[id]
+		dw := DeadlockWait{Goroutine: id, Primitive: w.name, Stack: w.stack}
+		for h := range g.holds[w.prim] {
+			dw.Holders = append(dw.Holders, DeadlockHold{Goroutine: h.gid, Stack: h.stack})
+			queue = append(queue, h.gid)
+		}
+		err.Waits = append(err.Waits, dw)
+	}
+	return err
+}
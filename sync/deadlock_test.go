@@ -0,0 +1,123 @@
+package chansync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeadlockABBA(t *testing.T) {
+	found := make(chan *DeadlockError, 2)
+	EnableDeadlockDetection(func(err *DeadlockError) { found <- err })
+	defer DisableDeadlockDetection()
+
+	a, b := NewLock(), NewLock()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// each goroutine takes its first lock, waits until the other has taken
+	// its own, then tries to take the other's
+	held := make(chan struct{}, 2)
+	done := make(chan struct{}, 2)
+	lock := func(first, second Lock) {
+		u := first.Acquire()
+		held <- struct{}{}
+		for len(held) < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		if v, err := second.AcquireContext(ctx); err == nil {
+			v.Release()
+		}
+		u.Release()
+		done <- struct{}{}
+	}
+	go lock(a, b)
+	go lock(b, a)
+
+	var err *DeadlockError
+	select {
+	case err = <- found:
+	case <- time.After(5 * time.Second):
+		t.Fatal("the deadlock was not detected")
+	}
+
+	// the goroutine that completed the deadlock is still blocked, so let
+	// both give up
+	cancel()
+	<- done
+	<- done
+
+	if len(err.Waits) != 2 {
+		t.Fatalf("got %d waits, want 2:\n%v", len(err.Waits), err)
+	}
+	for _, w := range err.Waits {
+		if len(w.Stack) == 0 {
+			t.Errorf("goroutine %d has no wait stack", w.Goroutine)
+		}
+		if len(w.Holders) != 1 {
+			t.Fatalf("goroutine %d waits on %d holders, want 1", w.Goroutine, len(w.Holders))
+		}
+		h := w.Holders[0]
+		if h.Goroutine == w.Goroutine {
+			t.Errorf("goroutine %d waits on itself", w.Goroutine)
+		}
+		if len(h.Stack) == 0 {
+			t.Errorf("the hold of goroutine %d has no stack", h.Goroutine)
+		}
+	}
+	if err.Waits[0].Goroutine != err.Waits[1].Holders[0].Goroutine || err.Waits[1].Goroutine != err.Waits[0].Holders[0].Goroutine {
+		t.Errorf("the waits do not form a cycle:\n%v", err)
+	}
+	if len(found) != 0 {
+		t.Errorf("the deadlock was reported more than once")
+	}
+}
+
+func TestDeadlockDetectorIdleWhenDisabled(t *testing.T) {
+	// another diagnostic creates hold records, but the detector must not
+	// record them unless it is enabled
+	defer SetContentionProfiling(SetContentionProfiling(true))
+
+	l := NewLock()
+	u := l.Acquire()
+	detector.mu.lock()
+	_, held := detector.holds[l]
+	detector.mu.unlock()
+	u.Release()
+
+	if held {
+		t.Error("the detector recorded a hold while disabled")
+	}
+}
+
+func TestDeadlockSemaphoreRelease(t *testing.T) {
+	EnableDeadlockDetection(nil)
+	defer DisableDeadlockDetection()
+
+	// Release used to loop forever once it had returned the resources, so
+	// the first release by a tracked acquisition never returned
+	s := NewSemaphore(2, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			u := s.Acquire(2)
+			waiter := make(chan struct{})
+			go func() {
+				defer close(waiter)
+				s.Acquire(1).ReleaseAll()
+			}()
+			u.ReleaseAll()
+			<- waiter
+		}
+	}()
+
+	select {
+	case <- done:
+	case <- time.After(5 * time.Second):
+		t.Fatal("Release did not return")
+	}
+	if got := s.Available(); got != 2 {
+		t.Errorf("Available() = %d, want 2", got)
+	}
+}
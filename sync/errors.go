@@ -0,0 +1,67 @@
+package chansync
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrWouldBlock is the error form of ChannelOpFailure. It is returned
+	// when an operation cannot complete without blocking.
+	ErrWouldBlock = errors.New("chansync: operation would block")
+
+	// ErrTimeout is the error form of ChannelOpTimeout. The Context variants
+	// of operations do not return it: they return ctx.Err(), so a deadline
+	// that expires is reported as context.DeadlineExceeded, which is not
+	// ErrTimeout as far as errors.Is is concerned. Code that uses both kinds
+	// of variant must check for both errors.
+	ErrTimeout = errors.New("chansync: operation timed out")
+
+	// ErrClosed is the error form of ChannelOpClosed.
+	ErrClosed = errors.New("chansync: channel closed")
+
+	// ErrPromotionPending is returned when a read lock cannot be promoted
+	// because another promotion or upgrade of the same lock is pending.
+	// Waiting could deadlock, since each waits for the other to release
+	// its read lock.
+	ErrPromotionPending = errors.New("chansync: another promotion is pending")
+
+	// ErrUpgradeUnsupported is returned when a read lock cannot be promoted,
+	// or an upgradable read lock upgraded, atomically, as with a
+	// ReadWriteLock returned by FromRWMutex.
+	ErrUpgradeUnsupported = errors.New("chansync: upgrade is not supported")
+)
+
+// String returns the name of the result.
+func (r ChannelOpResult) String() string {
+	switch r {
+	case ChannelOpSuccess:
+		return "success"
+	case ChannelOpFailure:
+		return "failure"
+	case ChannelOpTimeout:
+		return "timeout"
+	case ChannelOpClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("ChannelOpResult(%d)", uint8(r))
+	}
+}
+
+// Err converts the result into an error. Err returns nil for
+// ChannelOpSuccess, ErrWouldBlock for ChannelOpFailure, ErrTimeout for
+// ChannelOpTimeout, and ErrClosed for ChannelOpClosed.
+func (r ChannelOpResult) Err() error {
+	switch r {
+	case ChannelOpSuccess:
+		return nil
+	case ChannelOpFailure:
+		return ErrWouldBlock
+	case ChannelOpTimeout:
+		return ErrTimeout
+	case ChannelOpClosed:
+		return ErrClosed
+	default:
+		return fmt.Errorf("chansync: unknown result %d", uint8(r))
+	}
+}
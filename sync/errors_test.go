@@ -0,0 +1,112 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChannelOpResultString(t *testing.T) {
+	for r, want := range map[ChannelOpResult]string{
+		ChannelOpSuccess: "success",
+		ChannelOpFailure: "failure",
+		ChannelOpTimeout: "timeout",
+		ChannelOpClosed: "closed",
+		ChannelOpResult(42): "ChannelOpResult(42)",
+	} {
+		if got := r.String(); got != want {
+			t.Errorf("ChannelOpResult(%d).String() = %q, want %q", uint8(r), got, want)
+		}
+	}
+}
+
+func TestChannelOpResultErr(t *testing.T) {
+	for r, want := range map[ChannelOpResult]error{
+		ChannelOpSuccess: nil,
+		ChannelOpFailure: ErrWouldBlock,
+		ChannelOpTimeout: ErrTimeout,
+		ChannelOpClosed: ErrClosed,
+	} {
+		if got := r.Err(); got != want {
+			t.Errorf("%v.Err() = %v, want %v", r, got, want)
+		}
+	}
+
+	err := ChannelOpResult(42).Err()
+	if err == nil {
+		t.Fatal("an unknown result has no error")
+	}
+	for _, sentinel := range []error{ErrWouldBlock, ErrTimeout, ErrClosed} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("an unknown result is %v", sentinel)
+		}
+	}
+}
+
+func TestErrVariants(t *testing.T) {
+	ch := NewSyncChannelN(1)
+	if err := ch.TryRecvErr(); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TryRecvErr() = %v on an empty channel, want %v", err, ErrWouldBlock)
+	}
+	if err := ch.TimeoutRecvErr(10 * time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("TimeoutRecvErr() = %v on an empty channel, want %v", err, ErrTimeout)
+	}
+	if err := ch.TrySendErr(); err != nil {
+		t.Errorf("TrySendErr() = %v, want nil", err)
+	}
+	if err := ch.TrySendErr(); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TrySendErr() = %v on a full channel, want %v", err, ErrWouldBlock)
+	}
+	if err := ch.TimeoutSendErr(10 * time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("TimeoutSendErr() = %v on a full channel, want %v", err, ErrTimeout)
+	}
+	ch.Recv()
+	ch.Close()
+	if err := ch.TryRecvErr(); !errors.Is(err, ErrClosed) {
+		t.Errorf("TryRecvErr() = %v on a closed channel, want %v", err, ErrClosed)
+	}
+
+	e := NewEvent()
+	if err := e.TrySubscribeErr(10 * time.Millisecond); !errors.Is(err, ErrTimeout) {
+		t.Errorf("TrySubscribeErr() = %v with no publish, want %v", err, ErrTimeout)
+	}
+	e.Destroy()
+	if err := e.TrySubscribeErr(time.Hour); !errors.Is(err, ErrClosed) {
+		t.Errorf("TrySubscribeErr() = %v after Destroy, want %v", err, ErrClosed)
+	}
+
+	l := NewLock()
+	u, err := l.TryAcquireErr()
+	if err != nil {
+		t.Fatalf("TryAcquireErr() = %v on a free lock", err)
+	}
+	if _, err := l.TryAcquireErr(); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TryAcquireErr() = %v on a held lock, want %v", err, ErrWouldBlock)
+	}
+	u.Release()
+
+	s := NewSemaphore(2, 2)
+	p, err := s.TryAcquireErr(2)
+	if err != nil {
+		t.Fatalf("TryAcquireErr(2) = %v with 2 available", err)
+	}
+	if _, err := s.TryAcquireErr(1); !errors.Is(err, ErrWouldBlock) {
+		t.Errorf("TryAcquireErr(1) = %v with none available, want %v", err, ErrWouldBlock)
+	}
+	p.ReleaseAll()
+}
+
+func TestContextErrIsNotErrTimeout(t *testing.T) {
+	// as documented on ErrTimeout, the Context variants report an expired
+	// deadline as ctx.Err()
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	_, err := NewSyncChannel().RecvContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RecvContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("RecvContext() = %v, which is unexpectedly ErrTimeout", err)
+	}
+}
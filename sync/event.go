@@ -1,6 +1,7 @@
 package chansync
 
 import (
+	"context"
 	"time"
 )
 
@@ -17,64 +18,84 @@ type Event interface {
 	// the event is destroyed before publish is called, Subscribe returns
 	// ChannelOpClosed. Otherwise Subscribe returns ChannelOpSuccess.
 	Subscribe() ChannelOpResult
+	// SubscribeContext will block until unblocked by PublishOne or
+	// PublishAll, or until ctx is done. If ctx is done first, the
+	// subscription is withdrawn and SubscribeContext returns
+	// ChannelOpTimeout (deadline exceeded) or ChannelOpFailure (canceled)
+	// along with ctx.Err(). If the event is destroyed before publish is
+	// called, SubscribeContext returns ChannelOpClosed. Otherwise
+	// SubscribeContext returns ChannelOpSuccess.
+	SubscribeContext(ctx context.Context) (ChannelOpResult, error)
 	// TrySubscribe will block until unblocked by PublishOne or PublishAll,
 	// with a timeout. If the timeout expires, TrySubscribe returns
 	// ChannelOpTimeout. If the event is destroyed before publish is called,
 	// TrySubscribe returns ChannelOpClosed. Otherwise, TrySubscribe returns
 	// ChannelOpSuccess.
 	TrySubscribe(timeout time.Duration) ChannelOpResult
+	// TrySubscribeErr is TrySubscribe, reporting the result as an error.
+	// See ChannelOpResult.Err.
+	TrySubscribeErr(timeout time.Duration) error
 }
 
 
 type event struct {
-	subs []SyncChannel
+	// each subscriber is sent whether it was published to by PublishOne
+	subs []DataChannel[bool]
 
 	destroy SyncChannel
+	done SyncChannel
 	publish chan bool
-	newsubs chan SyncChannel
+	newsubs chan DataChannel[bool]
+	unsubs chan *eventUnsub
+}
+
+type eventUnsub struct {
+	sub DataChannel[bool]
+	ret chan bool
 }
 
 // NewEvent returns a new event.
 func NewEvent() Event {
 	e := &event{
-		subs: make([]SyncChannel, 0, 5),
+		subs: make([]DataChannel[bool], 0, 5),
 
 		destroy: NewSyncChannel(),
+		done: NewSyncChannel(),
 		publish: make(chan bool, 1),
-		newsubs: make(chan SyncChannel),
+		newsubs: make(chan DataChannel[bool]),
+		unsubs: make(chan *eventUnsub),
 	}
 
 	go func() {
 		for {
 			select {
 			case <- e.destroy:
-				e.destroy.Close()
-				close(e.publish)
-				close(e.newsubs)
+				e.done.Close()
 				for _, sub := range e.subs {
 					sub.Close()
 				}
-				break
+				return
 
 			case sub := <- e.newsubs:
 				e.subs = append(e.subs, sub)
 
+			case un := <- e.unsubs:
+				un.ret <- e.removeSub(un.sub)
+
 			case all := <- e.publish:
+				// subscriber channels are buffered and are only ever
+				// published to once, so Send never blocks
 				if (all) {
-					subs := e.subs
-					e.subs = make([]SyncChannel, 0, 5)
-					go func() {
-						for _, sub := range subs {
-							sub.Send()
-						}
-					}()
+					for _, sub := range e.subs {
+						sub.Send(false)
+					}
+					e.subs = make([]DataChannel[bool], 0, 5)
 				} else {
 					if len(e.subs) == 0 {
 						continue
 					}
-					sub := e.subs[0]
+					e.subs[0].Send(true)
 					e.subs = e.subs[1:]
-					go func() { sub.Send() }()
 				}
 			}
 		}
@@ -84,27 +105,107 @@ func NewEvent() Event {
 }
 
 func (e *event) Destroy() {
-	e.destroy.Send()
+	select {
+	case e.destroy <- empty:
+	case <- e.done:
+	}
 }
 
 func (e *event) PublishOne() {
-	e.publish <- false
+	select {
+	case e.publish <- false:
+	case <- e.done:
+	}
 }
 
 func (e *event) PublishAll() {
-	e.publish <- true
+	select {
+	case e.publish <- true:
+	case <- e.done:
+	}
 }
 
-func (e *event) newSub() SyncChannel {
-	sub := NewSyncChannelN(1)
-	go func() { e.newsubs <- sub }()
+// removeSub removes sub from the subscriber list, returning whether or not it
+// was found. It must only be called from the event goroutine.
+func (e *event) removeSub(sub DataChannel[bool]) bool {
+	for i, s := range e.subs {
+		if s == sub {
+			e.subs = append(e.subs[:i], e.subs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (e *event) newSub() DataChannel[bool] {
+	sub := NewDataChannelN[bool](1)
+	select {
+	case e.newsubs <- sub:
+	case <- e.done:
+		sub.Close()
+	}
 	return sub
 }
 
+// unsubscribe withdraws sub. If sub has already been published to, or the
+// event has been destroyed, unsubscribe returns false.
+func (e *event) unsubscribe(sub DataChannel[bool]) bool {
+	un := &eventUnsub{sub: sub, ret: make(chan bool)}
+	select {
+	case e.unsubs <- un:
+		return <- un.ret
+	case <- e.done:
+		return false
+	}
+}
+
+// subscribe subscribes to the event and waits for a publish or for cancel. If
+// cancel fires first, subscribe withdraws the subscription and returns
+// ChannelOpFailure. subscribe also returns whether the subscription was
+// published to by PublishOne.
+func (e *event) subscribe(cancel <-chan struct{}) (ChannelOpResult, bool) {
+	sub := e.newSub()
+	select {
+	case one, ok := <- sub:
+		if ok {
+			return ChannelOpSuccess, one
+		}
+		return ChannelOpClosed, false
+	case <- cancel:
+		if e.unsubscribe(sub) {
+			return ChannelOpFailure, false
+		}
+		// a publish raced with cancel; it has been (or is about to be)
+		// delivered to sub, so honor it rather than lose it
+		one, r := sub.Recv()
+		return r, one
+	}
+}
+
 func (e *event) Subscribe() ChannelOpResult {
-	return e.newSub().Recv()
+	r, _ := e.subscribe(nil)
+	return r
+}
+
+func (e *event) SubscribeContext(ctx context.Context) (ChannelOpResult, error) {
+	r, _ := e.subscribe(ctx.Done())
+	if r == ChannelOpFailure {
+		return contextResult(ctx)
+	}
+	return r, nil
 }
 
 func (e *event) TrySubscribe(timeout time.Duration) ChannelOpResult {
-	return e.newSub().TimeoutRecv(timeout)
-}
\ No newline at end of file
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	r, _ := e.subscribe(t.C())
+	if r == ChannelOpFailure {
+		return ChannelOpTimeout
+	}
+	return r
+}
+
+func (e *event) TrySubscribeErr(timeout time.Duration) error {
+	return e.TrySubscribe(timeout).Err()
+}
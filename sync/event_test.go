@@ -0,0 +1,57 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventSubscribeContext(t *testing.T) {
+	e := NewEvent()
+	defer e.Destroy()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if r, err := e.SubscribeContext(ctx); r != ChannelOpTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SubscribeContext() = (%v, %v) after the deadline, want (%v, %v)", r, err, ChannelOpTimeout, context.DeadlineExceeded)
+	}
+	if r, err := e.SubscribeContext(canceledContext()); r != ChannelOpFailure || !errors.Is(err, context.Canceled) {
+		t.Errorf("SubscribeContext() = (%v, %v) when canceled, want (%v, %v)", r, err, ChannelOpFailure, context.Canceled)
+	}
+
+	// both subscriptions were withdrawn, so PublishOne reaches a new
+	// subscriber rather than one that gave up
+	sub := e.(*event).newSub()
+	e.PublishOne()
+	select {
+	case one := <- sub:
+		if !one {
+			t.Error("the subscriber was published to by PublishAll")
+		}
+	case <- time.After(5 * time.Second):
+		t.Fatal("PublishOne went to a withdrawn subscription")
+	}
+
+	subscribed := goroutinesBlocked("select", "(*event).subscribe")
+	got := make(chan error)
+	go func() {
+		_, err := e.SubscribeContext(context.Background())
+		got <- err
+	}()
+	waitUntil(t, "the subscriber is subscribed", func() bool {
+		return goroutinesBlocked("select", "(*event).subscribe") == subscribed + 1
+	})
+	e.PublishAll()
+	if err := <- got; err != nil {
+		t.Errorf("SubscribeContext() = %v once published, want nil", err)
+	}
+}
+
+func TestEventSubscribeContextDestroyed(t *testing.T) {
+	e := NewEvent()
+	e.Destroy()
+	if r, err := e.SubscribeContext(context.Background()); r != ChannelOpClosed || err != nil {
+		t.Errorf("SubscribeContext() = (%v, %v) after Destroy, want (%v, nil)", r, err, ChannelOpClosed)
+	}
+}
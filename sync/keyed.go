@@ -0,0 +1,167 @@
+package chansync
+
+import (
+	"context"
+	"hash/maphash"
+)
+
+// KeyedLock provides mutual exclusion per key: acquisitions of the same key
+// exclude each other, and acquisitions of different keys do not.
+type KeyedLock[K comparable] interface {
+	// Acquire will block until the lock for key can be acquired. Acquire
+	// returns a reference that can be used to release the lock.
+	Acquire(key K) Unlock
+	// AcquireContext blocks until the lock for key can be acquired or ctx is
+	// done. If ctx is done first, AcquireContext returns (nil, ctx.Err()).
+	// Otherwise AcquireContext returns (u, nil) where u is a reference that
+	// can be used to release the lock.
+	AcquireContext(ctx context.Context, key K) (Unlock, error)
+	// TryAcquire attempts to acquire the lock for key. If the lock cannot be
+	// acquired, TryAcquire returns (nil, false). Otherwise TryAcquire returns
+	// (u, true) where u is a reference that can be used to release the lock.
+	TryAcquire(key K) (Unlock, bool)
+}
+
+type keyedLock[K comparable] struct {
+	// mu guards entries
+	mu mutex
+	entries map[K]*keyedEntry
+}
+
+// keyedEntry is the lock of a key that is held or being acquired.
+type keyedEntry struct {
+	lock Lock
+
+	// refs is the number of acquisitions holding or waiting for lock
+	refs int
+}
+
+type keyedUnlock[K comparable] struct {
+	token
+	lock *keyedLock[K]
+	key K
+	entry *keyedEntry
+	unlock Unlock
+}
+
+type stripedLock[K comparable] struct {
+	seed maphash.Seed
+	stripes []Lock
+}
+
+// NewKeyedLock returns a new KeyedLock. A key only uses memory while it is
+// held or being acquired.
+func NewKeyedLock[K comparable]() KeyedLock[K] {
+	return &keyedLock[K]{
+		mu: newMutex(),
+		entries: map[K]*keyedEntry{},
+	}
+}
+
+// NewStripedKeyedLock returns a new KeyedLock that uses a fixed number of
+// locks, so its memory does not depend on the number of keys. Each key is
+// hashed to one of the stripes, and keys that share a stripe exclude each
+// other as if they were the same key; in particular, acquiring a second key
+// while holding one can deadlock if both keys share a stripe.
+// NewStripedKeyedLock panics if stripes is not positive.
+func NewStripedKeyedLock[K comparable](stripes int) KeyedLock[K] {
+	if stripes <= 0 {
+		panic("chansync: NewStripedKeyedLock stripes must be positive")
+	}
+
+	l := &stripedLock[K]{
+		seed: maphash.MakeSeed(),
+		stripes: make([]Lock, stripes),
+	}
+	for i := range l.stripes {
+		l.stripes[i] = NewLock()
+	}
+	return l
+}
+
+func (l *keyedLock[K]) Acquire(key K) Unlock {
+	u, _ := l.AcquireContext(context.Background(), key)
+	return u
+}
+
+func (l *keyedLock[K]) AcquireContext(ctx context.Context, key K) (Unlock, error) {
+	e := l.ref(key)
+	u, err := e.lock.AcquireContext(ctx)
+	if err != nil {
+		l.unref(key, e)
+		return nil, err
+	}
+	return l.newUnlock(key, e, u), nil
+}
+
+func (l *keyedLock[K]) TryAcquire(key K) (Unlock, bool) {
+	e := l.ref(key)
+	u, ok := e.lock.TryAcquire()
+	if !ok {
+		l.unref(key, e)
+		return nil, false
+	}
+	return l.newUnlock(key, e, u), true
+}
+
+// ref returns the entry of key, creating it if necessary, and adds a
+// reference to it.
+func (l *keyedLock[K]) ref(key K) *keyedEntry {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	e := l.entries[key]
+	if e == nil {
+		e = &keyedEntry{lock: NewLock()}
+		l.entries[key] = e
+	}
+	e.refs++
+	return e
+}
+
+// unref removes a reference to the entry of key, deleting the entry once it
+// is unused.
+func (l *keyedLock[K]) unref(key K, e *keyedEntry) {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	e.refs--
+	if e.refs == 0 {
+		delete(l.entries, key)
+	}
+}
+
+func (l *keyedLock[K]) newUnlock(key K, e *keyedEntry, u Unlock) Unlock {
+	return &keyedUnlock[K]{
+		lock: l,
+		key: key,
+		entry: e,
+		unlock: u,
+	}
+}
+
+func (u *keyedUnlock[K]) Release() {
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+
+	u.unlock.Release()
+	u.lock.unref(u.key, u.entry)
+}
+
+// stripe returns the lock of the stripe of key.
+func (l *stripedLock[K]) stripe(key K) Lock {
+	return l.stripes[maphash.Comparable(l.seed, key) % uint64(len(l.stripes))]
+}
+
+func (l *stripedLock[K]) Acquire(key K) Unlock {
+	return l.stripe(key).Acquire()
+}
+
+func (l *stripedLock[K]) AcquireContext(ctx context.Context, key K) (Unlock, error) {
+	return l.stripe(key).AcquireContext(ctx)
+}
+
+func (l *stripedLock[K]) TryAcquire(key K) (Unlock, bool) {
+	return l.stripe(key).TryAcquire()
+}
@@ -0,0 +1,112 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// entries returns the number of keys that l has entries for.
+func entries[K comparable](l KeyedLock[K]) int {
+	k := l.(*keyedLock[K])
+	k.mu.lock()
+	defer k.mu.unlock()
+	return len(k.entries)
+}
+
+func TestKeyedLockRemovesUnusedEntries(t *testing.T) {
+	l := NewKeyedLock[string]()
+
+	a := l.Acquire("a")
+	b := l.Acquire("b")
+	if n := entries(l); n != 2 {
+		t.Fatalf("%d entries with two keys held, want 2", n)
+	}
+
+	// a waiter keeps the entry alive after the holder releases it
+	acquired := make(chan Unlock)
+	go func() { acquired <- l.Acquire("a") }()
+	waitUntil(t, "the waiter is counted", func() bool {
+		k := l.(*keyedLock[string])
+		k.mu.lock()
+		defer k.mu.unlock()
+		return k.entries["a"].refs == 2
+	})
+	a.Release()
+	(<- acquired).Release()
+	b.Release()
+
+	if n := entries(l); n != 0 {
+		t.Errorf("%d entries once every key is released, want 0", n)
+	}
+}
+
+func TestKeyedLockTryAcquire(t *testing.T) {
+	l := NewKeyedLock[int]()
+
+	u := l.Acquire(1)
+	if _, ok := l.TryAcquire(1); ok {
+		t.Fatal("TryAcquire(1) succeeded while 1 is held")
+	}
+	v, ok := l.TryAcquire(2)
+	if !ok {
+		t.Fatal("TryAcquire(2) failed while only 1 is held")
+	}
+	v.Release()
+	u.Release()
+
+	// a failed TryAcquire must not leave an entry behind
+	if n := entries(l); n != 0 {
+		t.Errorf("%d entries once every key is released, want 0", n)
+	}
+}
+
+func TestKeyedLockAcquireContext(t *testing.T) {
+	l := NewKeyedLock[int]()
+
+	u := l.Acquire(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := l.AcquireContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	u.Release()
+
+	if n := entries(l); n != 0 {
+		t.Errorf("%d entries once every key is released, want 0", n)
+	}
+	if u, ok := l.TryAcquire(1); !ok {
+		t.Error("the canceled acquisition left 1 held")
+	} else {
+		u.Release()
+	}
+}
+
+func TestStripedKeyedLock(t *testing.T) {
+	l := NewStripedKeyedLock[int](1)
+
+	// with one stripe, every key shares it
+	u := l.Acquire(1)
+	if _, ok := l.TryAcquire(2); ok {
+		t.Fatal("TryAcquire(2) succeeded while 1 holds the only stripe")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := l.AcquireContext(ctx, 3); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	u.Release()
+
+	l = NewStripedKeyedLock[int](4)
+	u = l.Acquire(1)
+	if _, ok := l.TryAcquire(1); ok {
+		t.Fatal("TryAcquire(1) succeeded while 1 is held")
+	}
+	u.Release()
+	u, ok := l.TryAcquire(1)
+	if !ok {
+		t.Fatal("TryAcquire(1) failed once 1 was released")
+	}
+	u.Release()
+}
@@ -0,0 +1,169 @@
+package chansync
+
+import (
+	"context"
+	"time"
+)
+
+// Lease is an acquisition with a time to live. If a lease is not released or
+// renewed before it expires, it is released automatically, so an acquisition
+// that is abandoned does not hold its lock or permits forever. Once a lease
+// has expired, its holder no longer has exclusive access; the holder can
+// learn this from Expired or Renew.
+type Lease interface {
+	// Release releases the acquisition before the lease expires. Calling
+	// Release after the lease has expired does nothing, since the holder
+	// cannot tell whether expiry will beat it, but Release must not be
+	// called more than once; see MisuseError.
+	Release()
+	// Renew extends the lease to expire after the specified duration from
+	// now. Renew returns false if the lease has already expired or been
+	// released, in which case it is not extended.
+	Renew(ttl time.Duration) bool
+	// Expired returns a channel that is closed when the lease expires. The
+	// channel is not closed if the lease is released first.
+	Expired() <-chan struct{}
+}
+
+type lease struct {
+	// token is consumed by Release
+	token
+	release func()
+	timer *timer
+	expired SyncChannel
+
+	// mu guards done, which is set by Release or by expiry, whichever
+	// comes first
+	mu mutex
+	done bool
+}
+
+// AcquireLease blocks until l is acquired, and returns a lease on it that
+// expires after ttl.
+func AcquireLease(l Lock, ttl time.Duration) Lease {
+	return newLease(l.Acquire().Release, ttl)
+}
+
+// AcquireLeaseContext blocks until l is acquired or ctx is done. If ctx is done
+// first, AcquireLeaseContext returns (nil, ctx.Err()). Otherwise
+// AcquireLeaseContext returns a lease on l that expires after ttl.
+func AcquireLeaseContext(ctx context.Context, l Lock, ttl time.Duration) (Lease, error) {
+	u, err := l.AcquireContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newLease(u.Release, ttl), nil
+}
+
+// TryAcquireLease attempts to acquire l. If l cannot be acquired,
+// TryAcquireLease returns (nil, false). Otherwise TryAcquireLease returns a
+// lease on l that expires after ttl.
+func TryAcquireLease(l Lock, ttl time.Duration) (Lease, bool) {
+	u, ok := l.TryAcquire()
+	if !ok {
+		return nil, false
+	}
+	return newLease(u.Release, ttl), true
+}
+
+// AcquirePermitLease blocks until n resources of s are obtained, and returns a
+// lease on them that expires after ttl. When the lease is released or expires,
+// the n resources are released.
+func AcquirePermitLease(s Semaphore, n int, ttl time.Duration) Lease {
+	return newLease(s.Acquire(n).ReleaseAll, ttl)
+}
+
+// AcquirePermitLeaseContext blocks until n resources of s are obtained or ctx
+// is done. If ctx is done first, AcquirePermitLeaseContext returns (nil,
+// ctx.Err()). Otherwise AcquirePermitLeaseContext returns a lease on the
+// resources that expires after ttl.
+func AcquirePermitLeaseContext(ctx context.Context, s Semaphore, n int, ttl time.Duration) (Lease, error) {
+	u, err := s.AcquireContext(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return newLease(u.ReleaseAll, ttl), nil
+}
+
+// TryAcquirePermitLease attempts to obtain n resources of s. If they cannot be
+// obtained, TryAcquirePermitLease returns (nil, false). Otherwise
+// TryAcquirePermitLease returns a lease on the resources that expires after
+// ttl.
+func TryAcquirePermitLease(s Semaphore, n int, ttl time.Duration) (Lease, bool) {
+	u, ok := s.TryAcquire(n)
+	if !ok {
+		return nil, false
+	}
+	return newLease(u.ReleaseAll, ttl), true
+}
+
+func newLease(release func(), ttl time.Duration) *lease {
+	l := &lease{
+		release: release,
+		expired: NewSyncChannel(),
+		mu: newMutex(),
+	}
+	l.timer = afterFunc(ttl, l.expire)
+	return l
+}
+
+// finish marks the lease as done, and returns false if it already was.
+func (l *lease) finish() bool {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	if l.done {
+		return false
+	}
+	l.done = true
+	return true
+}
+
+func (l *lease) expire() {
+	// release while holding mu, so that a Release that loses to expiry
+	// returns after the acquisition is released
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	if l.done {
+		return
+	}
+	l.done = true
+
+	l.release()
+	l.expired.Close()
+}
+
+func (l *lease) Release() {
+	if l.consume("Lease", useRelease) != nil {
+		return
+	}
+
+	if !l.finish() {
+		return
+	}
+
+	l.timer.Stop()
+	l.release()
+}
+
+func (l *lease) Renew(ttl time.Duration) bool {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	if l.done {
+		return false
+	}
+
+	// if the timer has fired, expire is waiting for mu and the lease
+	// expires regardless
+	if !l.timer.Reset(ttl) {
+		l.timer.Stop()
+		return false
+	}
+	return true
+}
+
+func (l *lease) Expired() <-chan struct{} {
+	return l.expired
+}
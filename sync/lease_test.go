@@ -0,0 +1,103 @@
+package chansync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseExpiry(t *testing.T) {
+	l := NewLock()
+	lease := AcquireLease(l, 10 * time.Millisecond)
+
+	select {
+	case <- lease.Expired():
+	case <- time.After(5 * time.Second):
+		t.Fatal("the lease did not expire")
+	}
+	if !free(l) {
+		t.Fatal("the lock is held after the lease expired")
+	}
+	if lease.Renew(time.Hour) {
+		t.Error("Renew succeeded after the lease expired")
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	l := NewLock()
+	lease := AcquireLease(l, 200 * time.Millisecond)
+
+	// keep renewing past the original time to live
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if !lease.Renew(200 * time.Millisecond) {
+			t.Fatal("Renew failed before the lease expired")
+		}
+	}
+	select {
+	case <- lease.Expired():
+		t.Fatal("the lease expired although it was renewed")
+	default:
+	}
+	if free(l) {
+		t.Fatal("the lock is free while the lease is held")
+	}
+
+	lease.Release()
+	if !free(l) {
+		t.Fatal("the lock is held after the lease was released")
+	}
+	if lease.Renew(time.Hour) {
+		t.Error("Renew succeeded after the lease was released")
+	}
+	select {
+	case <- lease.Expired():
+		t.Error("Expired was closed after the lease was released")
+	case <- time.After(100 * time.Millisecond):
+	}
+}
+
+func TestLeaseReleaseAfterExpiry(t *testing.T) {
+	caught := catchMisuse(t)
+	s := NewSemaphore(2, 2)
+	lease := AcquirePermitLease(s, 2, 10 * time.Millisecond)
+	<- lease.Expired()
+
+	// the expiry released the resources, and Release must not release them
+	// again or report misuse
+	lease.Release()
+	if got := s.Available(); got != 2 {
+		t.Errorf("Available() = %d, want 2", got)
+	}
+	if errs := caught(); len(errs) != 0 {
+		t.Fatalf("Release after expiry reported %v", errs[0])
+	}
+
+	// releasing twice is still misuse
+	lease.Release()
+	errs := caught()
+	if len(errs) != 1 {
+		t.Fatalf("got %d misuse reports, want 1", len(errs))
+	}
+	if errs[0].Op != "Lease.Release" || errs[0].Prior != "Release" {
+		t.Errorf("got %q after %q, want Lease.Release after Release", errs[0].Op, errs[0].Prior)
+	}
+}
+
+func TestLeaseReleaseRacesExpiry(t *testing.T) {
+	// no misuse handler is installed, so a Release that loses to expiry and
+	// is reported as misuse panics
+	old := SetMisuseHandler(nil)
+	defer SetMisuseHandler(old)
+
+	s := NewSemaphore(1, 1)
+	for i := 0; i < 1000; i++ {
+		lease := AcquirePermitLease(s, 1, time.Duration(i % 50) * time.Microsecond)
+		time.Sleep(time.Duration(i % 37) * time.Microsecond)
+		lease.Release()
+
+		// the resource is released exactly once, by Release or by expiry
+		if got := s.Available(); got != 1 {
+			t.Fatalf("iteration %d: Available() = %d, want 1", i, got)
+		}
+	}
+}
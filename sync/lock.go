@@ -1,63 +1,183 @@
 package chansync
 
-// Lock is a simple lock driven by channels. Nothing special here. Lock
-// acquisition is first-come, first-served.
+import (
+	"context"
+	"time"
+)
+
+// Lock is a simple lock driven by channels. The order in which competing
+// acquisitions are granted is determined by the lock's LockPolicy; see
+// NewLockWithPolicy.
 type Lock interface {
 	// Acquire will block until the lock can be acquired. Acquire returns a
 	// reference that can be used to release the lock.
 	Acquire() Unlock
+	// AcquireContext blocks until the lock can be acquired or ctx is done. If
+	// ctx is done first, AcquireContext returns (nil, ctx.Err()) and the lock
+	// is not acquired. Otherwise AcquireContext returns (u, nil) where u is a
+	// reference that can be used to release the lock.
+	AcquireContext(ctx context.Context) (Unlock, error)
 	// TryAcquire attempts to acquire the lock. If the lock cannot be acquired,
 	// TryAcquire returns (nil, false). If the lock is acquired, TryAcquire
 	// returns (u, bool) where u is a reference that can be used to release the
 	// lock.
 	TryAcquire() (Unlock, bool)
+	// TryAcquireErr is TryAcquire, reporting failure as ErrWouldBlock.
+	TryAcquireErr() (Unlock, error)
+	// AcquireTimeout attempts to acquire the lock, with a timeout. If the
+	// timeout expires, AcquireTimeout returns (nil, ChannelOpTimeout) and the
+	// lock is not acquired. Otherwise AcquireTimeout returns (u,
+	// ChannelOpSuccess) where u is a reference that can be used to release
+	// the lock.
+	AcquireTimeout(timeout time.Duration) (Unlock, ChannelOpResult)
+	// AcquireUntil is AcquireTimeout with an absolute deadline.
+	AcquireUntil(deadline time.Time) (Unlock, ChannelOpResult)
 }
 
 // Unlock is a reference that can be used to release a lock.
 type Unlock interface {
-	// Release releases the lock.
+	// Release releases the lock. Release must be called exactly once; see
+	// MisuseError.
 	Release()
 }
 
 type lock struct {
-	ch SyncChannel
+	classed
+	core lockCore
+
+	// id orders locks for AcquireAll
+	id uint64
+
+	// internal locks guard the state of other primitives, and are not
+	// tracked by diagnostics
+	internal bool
+}
+
+// lockCore implements the acquisition policy of a lock.
+type lockCore interface {
+	// acquire blocks until the lock is acquired or cancel fires, and
+	// returns whether the lock was acquired. A caller that gives up must
+	// never own the lock.
+	acquire(cancel <-chan struct{}) bool
+	// tryAcquire acquires the lock if that can be done without blocking.
+	tryAcquire() bool
+	// release releases the lock.
+	release()
 }
 
 type unlock struct {
-	ch SyncChannel
+	token
+	lock *lock
+	hold *holdRecord
 }
 
-// NewLock returns a new Lock
+// NewLock returns a new Lock with the LockHandoff policy
 func NewLock() Lock {
+	return NewLockWithPolicy(LockHandoff)
+}
+
+// NewLockWithPolicy returns a new Lock with the specified policy
+func NewLockWithPolicy(p LockPolicy) Lock {
+	var core lockCore
+	switch p {
+	case LockHandoff:
+		core = newHandoffLock()
+	case LockFIFO:
+		core = newFIFOLock()
+	case LockBarging:
+		core = newBargingLock()
+	default:
+		panic("Invalid lock policy")
+	}
+
+	return &lock{
+		core: core,
+		id: lockIDs.Add(1),
+	}
+}
+
+// newInternalLock returns a lock that is not tracked by diagnostics
+func newInternalLock() *lock {
 	return &lock{
-		ch: NewSyncChannelN(1),
+		core: newHandoffLock(),
+		internal: true,
+	}
+}
+
+// acquire blocks until the lock is acquired or cancel fires, recording the
+// wait for diagnostics.
+func (l *lock) acquire(cancel <-chan struct{}) (*holdRecord, bool) {
+	if l.internal {
+		return nil, l.core.acquire(cancel)
+	}
+
+	w := beginWait(l, "Lock")
+	ok := l.core.tryAcquire()
+	if !ok {
+		w.block()
+		ok = l.core.acquire(cancel)
 	}
+	return w.end(ok), ok
 }
 
 func (l *lock) Acquire() Unlock {
-	l.ch.Send()
-	u := l.newUnlock()
+	h, _ := l.acquire(nil)
+	u := l.newUnlock(h)
 	return u
 }
 
+func (l *lock) AcquireContext(ctx context.Context) (Unlock, error) {
+	h, ok := l.acquire(ctx.Done())
+	if !ok {
+		return nil, ctx.Err()
+	}
+	return l.newUnlock(h), nil
+}
+
+func (l *lock) AcquireTimeout(timeout time.Duration) (Unlock, ChannelOpResult) {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	h, ok := l.acquire(t.C())
+	if !ok {
+		return nil, ChannelOpTimeout
+	}
+	return l.newUnlock(h), ChannelOpSuccess
+}
+
+func (l *lock) AcquireUntil(deadline time.Time) (Unlock, ChannelOpResult) {
+	return l.AcquireTimeout(time.Until(deadline))
+}
+
 func (l *lock) TryAcquire() (Unlock, bool) {
-	if l.ch.TrySend() == ChannelOpSuccess {
-		return l.newUnlock(), true
+	if !l.core.tryAcquire() {
+		return nil, false
+	}
+	if l.internal {
+		return l.newUnlock(nil), true
 	}
-	return nil, false
+	return l.newUnlock(beginHold(l, "Lock")), true
 }
 
-func (l *lock) newUnlock() Unlock {
-	u := &unlock {
-		ch: NewSyncChannel(),
+func (l *lock) TryAcquireErr() (Unlock, error) {
+	if u, ok := l.TryAcquire(); ok {
+		return u, nil
+	}
+	return nil, ErrWouldBlock
+}
+
+func (l *lock) newUnlock(h *holdRecord) Unlock {
+	return &unlock{
+		lock: l,
+		hold: h,
 	}
-	go func() {
-		u.ch.Recv()
-		l.ch.Recv()
-	}()
-	return u
 }
 
 func (u *unlock) Release() {
-	u.ch.Send()
-}
\ No newline at end of file
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+
+	u.hold.end()
+	u.lock.core.release()
+}
@@ -0,0 +1,204 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var lockPolicies = map[string]LockPolicy{
+	"handoff": LockHandoff,
+	"fifo": LockFIFO,
+	"barging": LockBarging,
+}
+
+func TestLockAcquireContext(t *testing.T) {
+	for name, p := range lockPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewLockWithPolicy(p)
+			held := l.Acquire()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+			defer cancel()
+			if u, err := l.AcquireContext(ctx); u != nil || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("AcquireContext() = (%v, %v) after the deadline, want (nil, %v)", u, err, context.DeadlineExceeded)
+			}
+			if u, err := l.AcquireContext(canceledContext()); u != nil || !errors.Is(err, context.Canceled) {
+				t.Errorf("AcquireContext() = (%v, %v) when canceled, want (nil, %v)", u, err, context.Canceled)
+			}
+
+			// neither acquisition is left waiting for the lock
+			held.Release()
+			if !free(l) {
+				t.Fatal("the lock is held after the acquisitions gave up")
+			}
+		})
+	}
+}
+
+func TestLockAcquireTimeoutLeavesQueue(t *testing.T) {
+	for name, p := range lockPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewLockWithPolicy(p)
+			held := l.Acquire()
+
+			// a second waiter queues behind the one that times out
+			acquired := make(chan Unlock)
+			go func() { acquired <- l.Acquire() }()
+
+			if u, r := l.AcquireTimeout(20 * time.Millisecond); r != ChannelOpTimeout || u != nil {
+				t.Fatalf("AcquireTimeout() = (%v, %v), want (nil, %v)", u, r, ChannelOpTimeout)
+			}
+
+			// the lock goes to the second waiter, not the one that left
+			held.Release()
+			select {
+			case u := <- acquired:
+				u.Release()
+			case <- time.After(5 * time.Second):
+				t.Fatal("the waiter behind the timed out one did not acquire the lock")
+			}
+			if !free(l) {
+				t.Fatal("the lock is held after every acquisition was released")
+			}
+		})
+	}
+}
+
+func TestLockGiveUpWhileHandedLock(t *testing.T) {
+	for name, p := range map[string]LockPolicy{"fifo": LockFIFO, "barging": LockBarging} {
+		t.Run(name, func(t *testing.T) {
+			l := NewLockWithPolicy(p)
+			var mu mutex
+			var fn string
+			switch c := l.(*lock).core.(type) {
+			case *fifoLock:
+				mu, fn = c.mu, "fifoLock"
+			case *bargingLock:
+				mu, fn = c.mu, "bargingLock"
+			}
+
+			held := l.Acquire()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			gaveUp := make(chan error)
+			go func() {
+				_, err := l.AcquireContext(ctx)
+				gaveUp <- err
+			}()
+			waitUntil(t, "the first waiter is queued", func() bool { return queued(l) == 1 })
+			acquired := make(chan Unlock)
+			go func() { acquired <- l.Acquire() }()
+			waitUntil(t, "the second waiter is queued", func() bool { return queued(l) == 2 })
+
+			// with the lock's state locked, queue the release and then the
+			// first waiter giving up, so that the release hands the lock to
+			// the waiter or wakes it just before it gives up
+			mu.lock()
+			go held.Release()
+			waitUntil(t, "the release is blocked", func() bool {
+				return goroutinesBlocked("chan send", fn + ").release") == 1
+			})
+			cancel()
+			waitUntil(t, "the first waiter is giving up", func() bool {
+				return goroutinesBlocked("chan send", fn + ").acquire") == 1
+			})
+			mu.unlock()
+
+			if err := <- gaveUp; err == nil {
+				t.Fatal("the waiter acquired the lock after its context was canceled")
+			}
+
+			// the waiter that gave up must pass the lock on, or wake the
+			// next waiter in its place
+			select {
+			case u := <- acquired:
+				u.Release()
+			case <- time.After(5 * time.Second):
+				t.Fatal("the second waiter never acquired the lock")
+			}
+			if !free(l) {
+				t.Fatal("the lock is held after every acquisition was released")
+			}
+		})
+	}
+}
+
+func TestLockAcquireUntilPastDeadline(t *testing.T) {
+	for name, p := range lockPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewLockWithPolicy(p)
+			held := l.Acquire()
+
+			start := time.Now()
+			if u, r := l.AcquireUntil(start.Add(-time.Second)); r != ChannelOpTimeout || u != nil {
+				t.Fatalf("AcquireUntil() = (%v, %v), want (nil, %v)", u, r, ChannelOpTimeout)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("AcquireUntil took %v with a deadline in the past", d)
+			}
+
+			held.Release()
+			if !free(l) {
+				t.Fatal("the lock is held after AcquireUntil gave up")
+			}
+		})
+	}
+}
+
+// goroutineLock is how Lock worked before it released directly: every
+// acquisition started a goroutine that waited for the Unlock to be released,
+// and then released the lock.
+type goroutineLock struct {
+	ch SyncChannel
+}
+
+type goroutineUnlock struct {
+	ch SyncChannel
+}
+
+func newGoroutineLock() *goroutineLock {
+	return &goroutineLock{ch: NewSyncChannelN(1)}
+}
+
+func (l *goroutineLock) Acquire() Unlock {
+	l.ch.Send()
+	u := &goroutineUnlock{ch: NewSyncChannel()}
+	go func() {
+		u.ch.Recv()
+		l.ch.Recv()
+	}()
+	return u
+}
+
+func (u *goroutineUnlock) Release() {
+	u.ch.Send()
+}
+
+// benchmarkLocks runs f against Lock and against the goroutine per
+// acquisition implementation it replaced.
+func benchmarkLocks(b *testing.B, f func(b *testing.B, l interface{ Acquire() Unlock })) {
+	b.Run("direct", func(b *testing.B) { f(b, NewLock()) })
+	b.Run("goroutine", func(b *testing.B) { f(b, newGoroutineLock()) })
+}
+
+func BenchmarkLockAcquireRelease(b *testing.B) {
+	benchmarkLocks(b, func(b *testing.B, l interface{ Acquire() Unlock }) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			l.Acquire().Release()
+		}
+	})
+}
+
+func BenchmarkLockContended(b *testing.B) {
+	benchmarkLocks(b, func(b *testing.B, l interface{ Acquire() Unlock }) {
+		b.ReportAllocs()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				l.Acquire().Release()
+			}
+		})
+	})
+}
@@ -0,0 +1,277 @@
+package chansync
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// LockClass groups primitives that play the same role, such as every lock
+// guarding an instance of some type, so that the lock order validator treats
+// them as one. A Lock, ReadWriteLock or Semaphore that has not been assigned a
+// class is a class of its own.
+type LockClass struct {
+	name string
+}
+
+// NewLockClass returns a new LockClass with the specified name, which is used
+// in reports.
+func NewLockClass(name string) *LockClass {
+	return &LockClass{name: name}
+}
+
+func (c *LockClass) String() string {
+	return c.name
+}
+
+// SetLockClass assigns prim to class c. prim must be a Lock, ReadWriteLock or
+// Semaphore created by this package, and should be assigned its class before
+// it is first acquired. SetLockClass panics if prim is of any other type.
+func SetLockClass(prim any, c *LockClass) {
+	p, ok := prim.(classifiable)
+	if !ok {
+		panic(fmt.Sprintf("chansync: SetLockClass of unsupported %T", prim))
+	}
+	p.setLockClass(c)
+}
+
+type classifiable interface {
+	setLockClass(c *LockClass)
+	lockClass(name string) *LockClass
+}
+
+// classed is embedded by primitives that can be assigned a LockClass.
+type classed struct {
+	class atomic.Pointer[LockClass]
+}
+
+func (c *classed) setLockClass(lc *LockClass) {
+	c.class.Store(lc)
+}
+
+// lockClass returns the assigned class, assigning a new class with the
+// specified name if there is none.
+func (c *classed) lockClass(name string) *LockClass {
+	if lc := c.class.Load(); lc != nil {
+		return lc
+	}
+	c.class.CompareAndSwap(nil, NewLockClass(name))
+	return c.class.Load()
+}
+
+// classOf returns the class of prim, or nil if prim cannot be classified.
+func classOf(prim any, name string) *LockClass {
+	if p, ok := prim.(classifiable); ok {
+		return p.lockClass(name)
+	}
+	return nil
+}
+
+// LockOrderError describes an acquisition that inverts the order in which two
+// lock classes have previously been acquired. Acquiring Acquired while holding
+// Held is a potential deadlock, because Prior shows that Held has been
+// acquired while Acquired, perhaps indirectly, was held.
+type LockOrderError struct {
+	LockOrder
+	// Prior lists the earlier acquisitions that lead from Acquired back to
+	// Held, in order. Prior is empty if Held and Acquired are the same class.
+	Prior []LockOrder
+}
+
+// LockOrder describes the acquisition of one lock class while holding
+// another.
+type LockOrder struct {
+	// Held is the class that was held.
+	Held string
+	// Acquired is the class that was acquired.
+	Acquired string
+	// HeldStack is the stack trace of the acquisition of Held.
+	HeldStack []byte
+	// Stack is the stack trace of the acquisition of Acquired.
+	Stack []byte
+}
+
+func (e *LockOrderError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "chansync: lock order inversion: acquiring %s while holding %s", e.Acquired, e.Held)
+	if len(e.Prior) == 0 {
+		b.WriteString(", which is the same class")
+	}
+	fmt.Fprintf(&b, "\n\n%s was acquired at:\n%s\n%s was acquired at:\n%s", e.Held, e.HeldStack, e.Acquired, e.Stack)
+	for _, o := range e.Prior {
+		fmt.Fprintf(&b, "\n\npreviously, %s was acquired while holding %s\n\n%s was acquired at:\n%s\n%s was acquired at:\n%s", o.Acquired, o.Held, o.Held, o.HeldStack, o.Acquired, o.Stack)
+	}
+	return b.String()
+}
+
+var lockOrderHandler atomic.Pointer[func(*LockOrderError)]
+
+// EnableLockOrderValidation enables the lock order validator. While it is
+// enabled, each time a goroutine blocks to acquire a Lock, ReadWriteLock or
+// Semaphore, the validator records that the classes of every primitive the
+// goroutine holds were acquired before the class being acquired. The first
+// time an acquisition contradicts the recorded order, directly or through a
+// chain of other classes, h is called with the details and the acquisition
+// proceeds once h returns. Each inversion is reported once. If h is nil, the
+// goroutine panics with the *LockOrderError instead.
+//
+// TryAcquire and its variants cannot deadlock, so they do not record an order,
+// although the primitives they acquire count as held. Acquiring two primitives
+// of the same class while holding one of them is reported, since their order
+// cannot be told apart. As with EnableDeadlockDetection, the validator is
+// expensive, does not know of acquisitions made before it was enabled, and
+// assumes that acquisitions are released by the goroutine that made them.
+// Recorded orders are kept until the validator is disabled.
+func EnableLockOrderValidation(h func(*LockOrderError)) {
+	if h == nil {
+		h = func(err *LockOrderError) { panic(err) }
+	}
+	lockOrderHandler.Store(&h)
+}
+
+// DisableLockOrderValidation disables the lock order validator and forgets
+// every recorded order.
+func DisableLockOrderValidation() {
+	lockOrderHandler.Store(nil)
+	validator.reset()
+}
+
+// validator maintains the lock class graph
+var validator = newOrderGraph()
+
+type orderGraph struct {
+	// mu guards the fields below
+	mu mutex
+	held map[uint64]map[*holdRecord]struct{}
+	after map[*LockClass]map[*LockClass]*LockOrder
+	reported map[[2]*LockClass]bool
+}
+
+func newOrderGraph() *orderGraph {
+	g := &orderGraph{mu: newMutex()}
+	g.init()
+	return g
+}
+
+func (g *orderGraph) init() {
+	g.held = map[uint64]map[*holdRecord]struct{}{}
+	g.after = map[*LockClass]map[*LockClass]*LockOrder{}
+	g.reported = map[[2]*LockClass]bool{}
+}
+
+func (g *orderGraph) reset() {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	g.init()
+}
+
+// acquire records that the goroutine of w is acquiring w's class after
+// every class it holds, and reports the first inversion found.
+func (g *orderGraph) acquire(w *waitRecord) {
+	if w.class == nil {
+		return
+	}
+
+	g.mu.lock()
+	var err *LockOrderError
+	for h := range g.held[w.gid] {
+		if h.class == nil {
+			continue
+		}
+		if e := g.order(h, w); e != nil && err == nil {
+			err = e
+		}
+	}
+	g.mu.unlock()
+
+	if err == nil {
+		return
+	}
+	if h := lockOrderHandler.Load(); h != nil {
+		(*h)(err)
+	}
+}
+
+// order records that w's class is acquired after h's class, unless that
+// inverts a recorded order, in which case the inversion is returned the first
+// time it is found.
+func (g *orderGraph) order(h *holdRecord, w *waitRecord) *LockOrderError {
+	if _, ok := g.after[h.class][w.class]; ok {
+		return nil
+	}
+
+	o := LockOrder{Held: h.class.name, Acquired: w.class.name, HeldStack: h.stack, Stack: w.stack}
+	prior, inverted := g.path(w.class, h.class)
+	if !inverted && h.class != w.class {
+		after := g.after[h.class]
+		if after == nil {
+			after = map[*LockClass]*LockOrder{}
+			g.after[h.class] = after
+		}
+		after[w.class] = &o
+		return nil
+	}
+
+	key := [2]*LockClass{h.class, w.class}
+	if g.reported[key] {
+		return nil
+	}
+	g.reported[key] = true
+	return &LockOrderError{LockOrder: o, Prior: prior}
+}
+
+// path returns the recorded orders leading from class from to class to, if
+// there are any.
+func (g *orderGraph) path(from, to *LockClass) ([]LockOrder, bool) {
+	if from == to {
+		return nil, false
+	}
+
+	prev := map[*LockClass]*LockClass{from: nil}
+	queue := []*LockClass{from}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for next := range g.after[c] {
+			if _, seen := prev[next]; seen {
+				continue
+			}
+			prev[next] = c
+			if next != to {
+				queue = append(queue, next)
+				continue
+			}
+
+			var path []LockOrder
+			for c := to; prev[c] != nil; c = prev[c] {
+				path = append([]LockOrder{*g.after[prev[c]][c]}, path...)
+			}
+			return path, true
+		}
+	}
+	return nil, false
+}
+
+func (g *orderGraph) hold(h *holdRecord) {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	held := g.held[h.gid]
+	if held == nil {
+		held = map[*holdRecord]struct{}{}
+		g.held[h.gid] = held
+	}
+	held[h] = struct{}{}
+}
+
+func (g *orderGraph) unhold(h *holdRecord) {
+	g.mu.lock()
+	defer g.mu.unlock()
+
+	held := g.held[h.gid]
+	delete(held, h)
+	if len(held) == 0 {
+		delete(g.held, h.gid)
+	}
+}
@@ -0,0 +1,236 @@
+package chansync
+
+// LockPolicy determines the order in which a Lock grants competing
+// acquisitions.
+type LockPolicy uint8
+
+const (
+	// LockHandoff hands the lock directly to a blocked Acquire when it is
+	// released, so the lock is never free while acquisitions are waiting and
+	// TryAcquire cannot take it from them. Waiters are queued by the Go
+	// runtime's channel send queue, which is first-come, first-served in
+	// practice but is not a documented guarantee of the language. This is
+	// the policy used by NewLock, and the cheapest of the three.
+	LockHandoff LockPolicy = iota
+
+	// LockFIFO is a strict ticket lock. Every acquisition that has to wait
+	// takes its place in line when it is called, and the lock is handed to
+	// waiters strictly in that order. TryAcquire only succeeds if the lock
+	// is free and nobody is waiting. A waiter that gives up leaves the line
+	// without ever owning the lock.
+	LockFIFO
+
+	// LockBarging frees the lock when it is released and wakes the oldest
+	// waiter, which must then compete for it. Any Acquire or TryAcquire that
+	// arrives in the meantime may take the lock first, in which case the
+	// woken waiter goes back to the end of the line. Barging favors
+	// throughput over fairness, and a waiter can be starved.
+	LockBarging
+)
+
+// mutex is a bare channel lock that guards internal state.
+type mutex SyncChannel
+
+func newMutex() mutex {
+	return mutex(NewSyncChannelN(1))
+}
+
+func (m mutex) lock() {
+	m <- empty
+}
+
+func (m mutex) unlock() {
+	<- m
+}
+
+// waitQueue is a first-in, first-out queue of waiters. Each waiter is a
+// buffered channel, so it can be signaled without blocking.
+type waitQueue []SyncChannel
+
+func (q *waitQueue) push() SyncChannel {
+	w := NewSyncChannelN(1)
+	*q = append(*q, w)
+	return w
+}
+
+// pop removes and returns the oldest waiter, or nil if the queue is empty.
+func (q *waitQueue) pop() SyncChannel {
+	if len(*q) == 0 {
+		return nil
+	}
+	w := (*q)[0]
+	(*q)[0] = nil
+	*q = (*q)[1:]
+	return w
+}
+
+// remove removes w from the queue, returning whether or not it was found.
+func (q *waitQueue) remove(w SyncChannel) bool {
+	for i, x := range *q {
+		if x == w {
+			*q = append((*q)[:i], (*q)[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// handoffLock is a lock whose channel is full while the lock is held. When a
+// full buffered channel is received from while senders are blocked, the Go
+// runtime moves the oldest sender's value into the buffer, so releasing the
+// lock hands it directly to that sender.
+type handoffLock SyncChannel
+
+func newHandoffLock() handoffLock {
+	return handoffLock(NewSyncChannelN(1))
+}
+
+func (l handoffLock) acquire(cancel <-chan struct{}) bool {
+	select {
+	case l <- empty:
+		return true
+	case <- cancel:
+		return false
+	}
+}
+
+func (l handoffLock) tryAcquire() bool {
+	return SyncChannel(l).TrySend() == ChannelOpSuccess
+}
+
+func (l handoffLock) release() {
+	// the lock is held, so its channel is full and Recv returns immediately
+	SyncChannel(l).Recv()
+}
+
+type fifoLock struct {
+	// mu guards the fields below
+	mu mutex
+	held bool
+	waiters waitQueue
+}
+
+func newFIFOLock() *fifoLock {
+	return &fifoLock{
+		mu: newMutex(),
+	}
+}
+
+func (l *fifoLock) acquire(cancel <-chan struct{}) bool {
+	l.mu.lock()
+	if !l.held && len(l.waiters) == 0 {
+		l.held = true
+		l.mu.unlock()
+		return true
+	}
+	w := l.waiters.push()
+	l.mu.unlock()
+
+	select {
+	case <- w:
+		// the lock was handed to this waiter
+		return true
+	case <- cancel:
+	}
+
+	l.mu.lock()
+	if l.waiters.remove(w) {
+		l.mu.unlock()
+		return false
+	}
+	l.mu.unlock()
+
+	// the lock was handed over while giving up, so pass it on
+	w.Recv()
+	l.release()
+	return false
+}
+
+func (l *fifoLock) tryAcquire() bool {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	if l.held || len(l.waiters) > 0 {
+		return false
+	}
+	l.held = true
+	return true
+}
+
+func (l *fifoLock) release() {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	// hand the lock to the next waiter; it remains held
+	if w := l.waiters.pop(); w != nil {
+		w.Send()
+		return
+	}
+	l.held = false
+}
+
+type bargingLock struct {
+	// mu guards the fields below
+	mu mutex
+	held bool
+	waiters waitQueue
+}
+
+func newBargingLock() *bargingLock {
+	return &bargingLock{
+		mu: newMutex(),
+	}
+}
+
+func (l *bargingLock) acquire(cancel <-chan struct{}) bool {
+	for {
+		l.mu.lock()
+		if !l.held {
+			l.held = true
+			l.mu.unlock()
+			return true
+		}
+		w := l.waiters.push()
+		l.mu.unlock()
+
+		select {
+		case <- w:
+			// woken by a release; compete for the lock again
+			continue
+		case <- cancel:
+		}
+
+		l.mu.lock()
+		if !l.waiters.remove(w) && !l.held {
+			// this waiter was woken while giving up, so wake another in
+			// its place
+			if next := l.waiters.pop(); next != nil {
+				next.Send()
+			}
+		}
+		l.mu.unlock()
+		return false
+	}
+}
+
+func (l *bargingLock) tryAcquire() bool {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	if l.held {
+		return false
+	}
+	l.held = true
+	return true
+}
+
+func (l *bargingLock) release() {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	// free the lock, then wake one waiter to compete for it
+	l.held = false
+	if w := l.waiters.pop(); w != nil {
+		w.Send()
+	}
+}
@@ -0,0 +1,98 @@
+package chansync
+
+import "testing"
+
+// queued returns the number of waiters queued by a LockFIFO or LockBarging
+// lock.
+func queued(l Lock) int {
+	switch c := l.(*lock).core.(type) {
+	case *fifoLock:
+		c.mu.lock()
+		defer c.mu.unlock()
+		return len(c.waiters)
+	case *bargingLock:
+		c.mu.lock()
+		defer c.mu.unlock()
+		return len(c.waiters)
+	}
+	panic("lock has no wait queue")
+}
+
+func TestLockFIFOOrder(t *testing.T) {
+	const n = 10
+	l := NewLockWithPolicy(LockFIFO)
+	held := l.Acquire()
+
+	// queue the waiters one at a time, so their order is known
+	order := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			u := l.Acquire()
+			order <- i
+			u.Release()
+		}()
+		waitUntil(t, "the waiter is queued", func() bool { return queued(l) == i + 1 })
+	}
+
+	held.Release()
+	for i := 0; i < n; i++ {
+		if got := <- order; got != i {
+			t.Fatalf("waiter %d acquired the lock in position %d", got, i)
+		}
+	}
+}
+
+// testTryAcquireBehindWaiter releases l while an Acquire is waiting for it,
+// and reports whether a TryAcquire made immediately afterwards succeeded.
+func testTryAcquireBehindWaiter(t *testing.T, l Lock, waiting func() bool) bool {
+	t.Helper()
+
+	held := l.Acquire()
+	acquired := make(chan Unlock)
+	go func() { acquired <- l.Acquire() }()
+	waitUntil(t, "the waiter is waiting", waiting)
+
+	held.Release()
+	u, ok := l.TryAcquire()
+	if ok {
+		u.Release()
+	}
+	(<- acquired).Release()
+	return ok
+}
+
+func TestLockTryAcquireBehindWaiter(t *testing.T) {
+	t.Run("handoff", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			l := NewLockWithPolicy(LockHandoff)
+			// the handoff queue belongs to the runtime, so look for the
+			// waiter blocked in it
+			blocked := goroutinesBlocked("select", "handoffLock.acquire")
+			waiting := func() bool { return goroutinesBlocked("select", "handoffLock.acquire") > blocked }
+			if testTryAcquireBehindWaiter(t, l, waiting) {
+				t.Fatal("TryAcquire jumped ahead of a waiter")
+			}
+		}
+	})
+
+	t.Run("fifo", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			l := NewLockWithPolicy(LockFIFO)
+			if testTryAcquireBehindWaiter(t, l, func() bool { return queued(l) == 1 }) {
+				t.Fatal("TryAcquire jumped ahead of a waiter")
+			}
+		}
+	})
+
+	t.Run("barging", func(t *testing.T) {
+		// the woken waiter races with TryAcquire, so TryAcquire need not
+		// win every time, but it must be able to
+		for i := 0; i < 100; i++ {
+			l := NewLockWithPolicy(LockBarging)
+			if testTryAcquireBehindWaiter(t, l, func() bool { return queued(l) == 1 }) {
+				return
+			}
+		}
+		t.Fatal("TryAcquire never took the lock ahead of a woken waiter")
+	})
+}
@@ -0,0 +1,128 @@
+package chansync
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// MisuseError describes the misuse of an Unlock, ReadUnlock, WriteUnlock,
+// UpgradableUnlock, Unsemaphore or Lease. Each of these is consumed by the
+// first call to Release, Promote, Demote, Upgrade or Downgrade, and any later
+// call is misuse. An Unsemaphore is consumed once its last resource is
+// released.
+type MisuseError struct {
+	// Op is the call that misused the token, such as "Unlock.Release".
+	Op string
+	// Prior is the call that consumed the token, such as "Release".
+	Prior string
+	// Stack is the stack trace of the call that consumed the token. It is
+	// only recorded if stack recording is enabled with RecordMisuseStacks.
+	Stack []byte
+	// Reason describes misuse of a token that has not been consumed, such as
+	// releasing more resources than an Unsemaphore holds. Prior and Stack
+	// are empty if Reason is set.
+	Reason string
+}
+
+func (e *MisuseError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("chansync: %s: %s", e.Op, e.Reason)
+	}
+
+	msg := fmt.Sprintf("chansync: %s called after %s", e.Op, e.Prior)
+	if len(e.Stack) == 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s\n\n%s was called from:\n%s", msg, e.Prior, e.Stack)
+}
+
+var (
+	misuseHandler atomic.Pointer[func(*MisuseError)]
+	misuseStacks atomic.Bool
+)
+
+// SetMisuseHandler sets the function that is called when a token is misused,
+// and returns the previous handler. The misused call does nothing after the
+// handler returns. If the handler is nil, which is the default, misuse panics
+// with a *MisuseError.
+func SetMisuseHandler(h func(*MisuseError)) func(*MisuseError) {
+	var old *func(*MisuseError)
+	if h == nil {
+		old = misuseHandler.Swap(nil)
+	} else {
+		old = misuseHandler.Swap(&h)
+	}
+	if old == nil {
+		return nil
+	}
+	return *old
+}
+
+// RecordMisuseStacks enables or disables recording the stack trace of the
+// call that consumes each token, so that misuse can report where the token was
+// consumed. Recording is disabled by default because it is expensive.
+// RecordMisuseStacks returns the previous setting.
+func RecordMisuseStacks(enabled bool) bool {
+	return misuseStacks.Swap(enabled)
+}
+
+// token tracks whether an Unlock, ReadUnlock, WriteUnlock, UpgradableUnlock,
+// Unsemaphore or Lease has been consumed. The zero value is a live token.
+type token struct {
+	used atomic.Pointer[tokenUse]
+}
+
+type tokenUse struct {
+	op string
+	stack []byte
+}
+
+// shared uses, for when stacks are not recorded
+var (
+	useRelease = &tokenUse{op: "Release"}
+	useReleaseAll = &tokenUse{op: "ReleaseAll"}
+	usePromote = &tokenUse{op: "Promote"}
+	useTryPromote = &tokenUse{op: "TryPromote"}
+	useDemote = &tokenUse{op: "Demote"}
+	useUpgrade = &tokenUse{op: "Upgrade"}
+	useTryUpgrade = &tokenUse{op: "TryUpgrade"}
+	useDowngrade = &tokenUse{op: "Downgrade"}
+)
+
+// consume marks the token as consumed by use. If the token has already been
+// consumed, consume reports the misuse of kind.use and returns the
+// *MisuseError.
+func (t *token) consume(kind string, use *tokenUse) error {
+	u := use
+	if misuseStacks.Load() {
+		u = &tokenUse{op: use.op, stack: debug.Stack()}
+	}
+
+	if t.used.CompareAndSwap(nil, u) {
+		return nil
+	}
+
+	err := &MisuseError{Op: kind + "." + use.op}
+	if prior := t.used.Load(); prior != nil {
+		err.Prior = prior.op
+		err.Stack = prior.stack
+	}
+	return reportMisuse(err)
+}
+
+// reportMisuse passes err to the misuse handler, or panics with it if there is
+// no handler, and returns err.
+func reportMisuse(err *MisuseError) error {
+	if h := misuseHandler.Load(); h != nil {
+		(*h)(err)
+		return err
+	}
+	panic(err)
+}
+
+// restore returns a consumed token to the live state. It is used when a
+// promotion fails and the read lock is retained.
+func (t *token) restore() {
+	t.used.Store(nil)
+}
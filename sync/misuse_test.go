@@ -0,0 +1,216 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// catchMisuse installs a misuse handler for the rest of the test, and returns
+// a function that returns the misuse reported so far.
+func catchMisuse(t *testing.T) func() []*MisuseError {
+	t.Helper()
+
+	var mu sync.Mutex
+	var caught []*MisuseError
+	old := SetMisuseHandler(func(err *MisuseError) {
+		mu.Lock()
+		defer mu.Unlock()
+		caught = append(caught, err)
+	})
+	t.Cleanup(func() { SetMisuseHandler(old) })
+
+	return func() []*MisuseError {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*MisuseError(nil), caught...)
+	}
+}
+
+// waitUntil polls cond until it returns true, failing the test if it does not
+// within a few seconds.
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// goroutinesBlocked returns the number of goroutines that are blocked in the
+// specified state, such as "chan send" or "select", with fn on their stack.
+func goroutinesBlocked(state, fn string) int {
+	buf := make([]byte, 1 << 16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// each goroutine is a "goroutine N [state]:" line followed by its stack,
+	// and goroutines are separated by blank lines
+	count := 0
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		header, _, _ := strings.Cut(g, "\n")
+		if strings.Contains(header, "[" + state) && strings.Contains(g, fn) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestMisuseDoubleRelease(t *testing.T) {
+	caught := catchMisuse(t)
+
+	u := NewLock().Acquire()
+	u.Release()
+	u.Release()
+
+	errs := caught()
+	if len(errs) != 1 {
+		t.Fatalf("got %d misuse reports, want 1", len(errs))
+	}
+	if errs[0].Op != "Unlock.Release" || errs[0].Prior != "Release" {
+		t.Errorf("got %q after %q, want Unlock.Release after Release", errs[0].Op, errs[0].Prior)
+	}
+}
+
+func TestMisusePanicsWithoutHandler(t *testing.T) {
+	old := SetMisuseHandler(nil)
+	defer SetMisuseHandler(old)
+
+	u := NewLock().Acquire()
+	u.Release()
+
+	defer func() {
+		var err *MisuseError
+		if e, ok := recover().(error); !ok || !errors.As(e, &err) {
+			t.Fatalf("got %v, want a *MisuseError panic", e)
+		}
+	}()
+	u.Release()
+}
+
+func TestMisuseReleaseAfterPromote(t *testing.T) {
+	caught := catchMisuse(t)
+
+	r := NewReadWriteLock().AcquireRead()
+	w := r.Promote()
+	r.Release()
+	w.Release()
+
+	errs := caught()
+	if len(errs) != 1 {
+		t.Fatalf("got %d misuse reports, want 1", len(errs))
+	}
+	if errs[0].Op != "ReadUnlock.Release" || errs[0].Prior != "Promote" {
+		t.Errorf("got %q after %q, want ReadUnlock.Release after Promote", errs[0].Op, errs[0].Prior)
+	}
+}
+
+func TestMisuseUseAfterDemote(t *testing.T) {
+	caught := catchMisuse(t)
+
+	l := NewReadWriteLock()
+	w := l.AcquireWrite()
+	r := w.Demote()
+	w.Release()
+	if d := w.Demote(); d != nil {
+		t.Error("a second Demote returned a read lock")
+	}
+
+	// the misuse left the demoted read lock alone
+	if _, ok := l.TryAcquireWrite(); ok {
+		t.Fatal("the write lock was acquired while the demoted read lock is held")
+	}
+	r.Release()
+
+	errs := caught()
+	if len(errs) != 2 {
+		t.Fatalf("got %d misuse reports, want 2", len(errs))
+	}
+	for i, op := range []string{"WriteUnlock.Release", "WriteUnlock.Demote"} {
+		if errs[i].Op != op || errs[i].Prior != "Demote" {
+			t.Errorf("got %q after %q, want %s after Demote", errs[i].Op, errs[i].Prior, op)
+		}
+	}
+}
+
+func TestMisuseTokenRestoredAfterFailedPromote(t *testing.T) {
+	caught := catchMisuse(t)
+
+	l := NewReadWriteLock()
+	other := l.AcquireRead()
+	r := l.AcquireRead()
+
+	// the other reader prevents both promotions
+	if _, ok := r.TryPromote(); ok {
+		t.Fatal("TryPromote succeeded while another read lock is held")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := r.PromoteContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("PromoteContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// r is still live, so it can be promoted once the other reader is gone
+	other.Release()
+	w, ok := r.TryPromote()
+	if !ok {
+		t.Fatal("TryPromote failed once the other read lock was released")
+	}
+	w.Release()
+
+	if errs := caught(); len(errs) != 0 {
+		t.Fatalf("a restored token reported %v", errs[0])
+	}
+}
+
+// consumeForStack releases u, so that its name is in the recorded stack.
+func consumeForStack(u Unlock) {
+	u.Release()
+}
+
+func TestMisuseRecordsStack(t *testing.T) {
+	caught := catchMisuse(t)
+	defer RecordMisuseStacks(RecordMisuseStacks(true))
+
+	u := NewLock().Acquire()
+	consumeForStack(u)
+	u.Release()
+
+	errs := caught()
+	if len(errs) != 1 {
+		t.Fatalf("got %d misuse reports, want 1", len(errs))
+	}
+	if !strings.Contains(string(errs[0].Stack), "consumeForStack") {
+		t.Errorf("the stack does not show the first Release:\n%s", errs[0].Stack)
+	}
+	if !strings.Contains(errs[0].Error(), "Release was called from:") {
+		t.Errorf("Error() does not include the stack:\n%s", errs[0].Error())
+	}
+}
+
+func TestMisuseNoStackByDefault(t *testing.T) {
+	caught := catchMisuse(t)
+	defer RecordMisuseStacks(RecordMisuseStacks(false))
+
+	u := NewLock().Acquire()
+	u.Release()
+	u.Release()
+
+	if errs := caught(); len(errs) != 1 || errs[0].Stack != nil {
+		t.Fatalf("got %v, want one report without a stack", errs)
+	}
+}
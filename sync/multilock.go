@@ -0,0 +1,200 @@
+package chansync
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// lockIDs numbers the locks created by NewLockWithPolicy
+var lockIDs atomic.Uint64
+
+type multiUnlock struct {
+	token
+	unlocks []Unlock
+}
+
+// AcquireAll blocks until every one of the specified locks is held, and
+// returns a reference that releases all of them. A lock that is specified more
+// than once is only acquired once.
+//
+// If every lock was created by NewLock or NewLockWithPolicy, the locks are
+// acquired one at a time in an order that is the same for every call, so
+// concurrent calls of AcquireAll cannot deadlock each other, whichever order
+// their locks are specified in. Otherwise no such order exists, and
+// AcquireAll acquires the locks all or nothing: it blocks acquiring one lock,
+// tries to acquire the others, and if any of them is held, releases
+// everything and starts over by blocking on the lock that was held.
+//
+// AcquireAll only orders the locks of a single call; it cannot prevent a
+// deadlock with code that acquires the same locks one by one in a different
+// order.
+func AcquireAll(locks ...Lock) Unlock {
+	u, _ := AcquireAllContext(context.Background(), locks...)
+	return u
+}
+
+// AcquireAllContext is AcquireAll, giving up if ctx is done first. If ctx is
+// done first, AcquireAllContext returns (nil, ctx.Err()) and none of the locks
+// are held.
+func AcquireAllContext(ctx context.Context, locks ...Lock) (Unlock, error) {
+	locks = distinctLocks(locks)
+
+	var unlocks []Unlock
+	var err error
+	if ordered(locks) {
+		unlocks, err = acquireOrdered(ctx, locks)
+	} else {
+		unlocks, err = acquireBackoff(ctx, locks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &multiUnlock{unlocks: unlocks}, nil
+}
+
+// TryAcquireAll attempts to acquire every one of the specified locks. If any
+// of them cannot be acquired, TryAcquireAll returns (nil, false) and none of
+// the locks are held. Otherwise TryAcquireAll returns (u, true) where u is a
+// reference that releases all of them.
+func TryAcquireAll(locks ...Lock) (Unlock, bool) {
+	locks = distinctLocks(locks)
+
+	unlocks, failed := tryAcquireAll(locks, -1)
+	if failed >= 0 {
+		return nil, false
+	}
+	return &multiUnlock{unlocks: unlocks}, true
+}
+
+// AcquireAllTimeout is AcquireAll with a timeout. If the timeout expires,
+// AcquireAllTimeout returns (nil, ChannelOpTimeout) and none of the locks are
+// held. Otherwise AcquireAllTimeout returns (u, ChannelOpSuccess) where u is a
+// reference that releases all of the locks.
+func AcquireAllTimeout(timeout time.Duration, locks ...Lock) (Unlock, ChannelOpResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	u, err := AcquireAllContext(ctx, locks...)
+	if err != nil {
+		return nil, ChannelOpTimeout
+	}
+	return u, ChannelOpSuccess
+}
+
+// distinctLocks returns locks without duplicates.
+func distinctLocks(locks []Lock) []Lock {
+	distinct := make([]Lock, 0, len(locks))
+outer:
+	for _, l := range locks {
+		for _, m := range distinct {
+			if sameLock(l, m) {
+				continue outer
+			}
+		}
+		distinct = append(distinct, l)
+	}
+	return distinct
+}
+
+// sameLock returns whether or not a and b are the same lock. Comparing
+// interface values panics if their dynamic type is not comparable, so such
+// locks are only the same if they are identical.
+func sameLock(a, b Lock) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
+// ordered returns whether or not every lock can be ordered, and if so, sorts
+// them into that order.
+func ordered(locks []Lock) bool {
+	for _, l := range locks {
+		if _, ok := l.(*lock); !ok {
+			return false
+		}
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].(*lock).id < locks[j].(*lock).id
+	})
+	return true
+}
+
+// acquireOrdered acquires the locks in order.
+func acquireOrdered(ctx context.Context, locks []Lock) ([]Unlock, error) {
+	unlocks := make([]Unlock, 0, len(locks))
+	for _, l := range locks {
+		u, err := l.AcquireContext(ctx)
+		if err != nil {
+			releaseAll(unlocks)
+			return nil, err
+		}
+		unlocks = append(unlocks, u)
+	}
+	return unlocks, nil
+}
+
+// acquireBackoff acquires the locks all or nothing.
+func acquireBackoff(ctx context.Context, locks []Lock) ([]Unlock, error) {
+	if len(locks) == 0 {
+		return nil, nil
+	}
+
+	first := 0
+	for {
+		u, err := locks[first].AcquireContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		unlocks, failed := tryAcquireAll(locks, first)
+		if failed < 0 {
+			unlocks[first] = u
+			return unlocks, nil
+		}
+
+		// back off, then wait for the lock that was held
+		u.Release()
+		first = failed
+	}
+}
+
+// tryAcquireAll attempts to acquire every lock other than locks[skip]. If all
+// of them are acquired, tryAcquireAll returns their unlocks, indexed like
+// locks, and -1. Otherwise tryAcquireAll releases the locks it acquired and
+// returns the index of the lock that could not be acquired.
+func tryAcquireAll(locks []Lock, skip int) ([]Unlock, int) {
+	unlocks := make([]Unlock, len(locks))
+	for i, l := range locks {
+		if i == skip {
+			continue
+		}
+
+		u, ok := l.TryAcquire()
+		if !ok {
+			releaseAll(unlocks)
+			return nil, i
+		}
+		unlocks[i] = u
+	}
+	return unlocks, -1
+}
+
+// releaseAll releases unlocks in reverse order, skipping nil entries.
+func releaseAll(unlocks []Unlock) {
+	for i := len(unlocks) - 1; i >= 0; i-- {
+		if unlocks[i] != nil {
+			unlocks[i].Release()
+		}
+	}
+}
+
+func (u *multiUnlock) Release() {
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+
+	releaseAll(u.unlocks)
+}
@@ -0,0 +1,110 @@
+package chansync
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// free reports whether or not every lock can be acquired, leaving them free.
+func free(locks ...Lock) bool {
+	for _, l := range locks {
+		u, ok := l.TryAcquire()
+		if !ok {
+			return false
+		}
+		u.Release()
+	}
+	return true
+}
+
+// testOppositeOrders runs AcquireAll concurrently on overlapping sets of
+// locks specified in opposite orders, failing if they deadlock.
+func testOppositeOrders(t *testing.T, a, b, c Lock) {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	for _, set := range [][]Lock{{a, b, c}, {c, b, a}, {b, a}, {c, a}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				AcquireAll(set...).Release()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <- done:
+	case <- time.After(10 * time.Second):
+		t.Fatal("AcquireAll deadlocked")
+	}
+	if !free(a, b, c) {
+		t.Error("a lock is still held")
+	}
+}
+
+func TestAcquireAllOppositeOrders(t *testing.T) {
+	t.Run("ordered", func(t *testing.T) {
+		testOppositeOrders(t, NewLock(), NewLockWithPolicy(LockFIFO), NewLockWithPolicy(LockBarging))
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		// locks backed by sync.Mutex cannot be ordered
+		var a, b, c sync.Mutex
+		testOppositeOrders(t, FromMutex(&a), FromMutex(&b), FromMutex(&c))
+	})
+}
+
+func TestTryAcquireAllRollback(t *testing.T) {
+	a, b, c := NewLock(), NewLock(), NewLock()
+
+	held := c.Acquire()
+	if _, ok := TryAcquireAll(a, b, c); ok {
+		t.Fatal("TryAcquireAll succeeded while c is held")
+	}
+	if !free(a, b) {
+		t.Fatal("TryAcquireAll left a lock held after failing")
+	}
+	held.Release()
+
+	u, ok := TryAcquireAll(a, b, c, a)
+	if !ok {
+		t.Fatal("TryAcquireAll failed with every lock free")
+	}
+	if free(a) || free(b) || free(c) {
+		t.Fatal("TryAcquireAll did not hold every lock")
+	}
+	u.Release()
+	if !free(a, b, c) {
+		t.Error("Release did not release every lock")
+	}
+}
+
+func TestAcquireAllTimeout(t *testing.T) {
+	var m sync.Mutex
+	for name, locks := range map[string][]Lock{
+		"ordered": {NewLock(), NewLock()},
+		"backoff": {NewLock(), FromMutex(&m)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			held := locks[1].Acquire()
+			if _, r := AcquireAllTimeout(10 * time.Millisecond, locks...); r != ChannelOpTimeout {
+				t.Fatalf("AcquireAllTimeout() = %v, want %v", r, ChannelOpTimeout)
+			}
+			if !free(locks[0]) {
+				t.Fatal("AcquireAllTimeout left a lock held after timing out")
+			}
+			held.Release()
+
+			// the abandoned acquisition of a mutex gives it back once it
+			// gets it
+			waitUntil(t, "every lock is free", func() bool { return free(locks...) })
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package chansync
+
+import (
+	"compress/gzip"
+	"io"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var profiling atomic.Bool
+
+// contentionQuantum is the time that each count in the chansync.contention
+// profile stands for.
+const contentionQuantum = 10 * time.Millisecond
+
+// contentionProfile is the chansync.contention profile. Its entries are keyed
+// by contentionEntry.
+var contentionProfile = pprof.NewProfile("chansync.contention")
+
+// contentionEntry is a key of the chansync.contention profile. Keys are
+// numbered from 1 in the order they are added.
+type contentionEntry uint64
+
+// SetContentionProfiling enables or disables contention profiling, and returns
+// the previous setting. Profiling is disabled by default.
+//
+// While profiling is enabled, the time each acquisition of a Lock,
+// ReadWriteLock or Semaphore spends waiting, and the time it is held, is
+// accounted to the call stack that made the acquisition. The accounting is
+// added to the chansync.contention profile, which is registered with
+// runtime/pprof, served by net/http/pprof as /debug/pprof/chansync.contention,
+// and viewable with go tool pprof. runtime/pprof profiles can only count, so
+// each count in that profile is 10ms of waiting or holding, and time shorter
+// than that is carried over to the next acquisition from the same stack.
+// Waiting is counted under chansync.(*contentionStore).chargeWait and holding
+// under chansync.(*contentionStore).chargeHold, so go tool pprof -focus can
+// tell them apart.
+//
+// runtime/pprof records the stack of the goroutine that adds to a profile, so
+// waiting is counted by the acquiring goroutine once it stops waiting, and the
+// time an acquisition is held is counted the next time the same call stack
+// acquires the same primitive. Every count is kept until
+// ResetContentionProfile. WriteContentionProfile writes the same accounting
+// without these limits.
+func SetContentionProfiling(enabled bool) bool {
+	return profiling.Swap(enabled)
+}
+
+// WriteContentionProfile writes the accumulated contention of chansync
+// primitives to w in the gzip-compressed protocol buffer format read by go
+// tool pprof. The profile attributes the number of acquisitions that had to
+// wait, the time they spent waiting, the number of acquisitions released and
+// the time they were held to the call stack that acquired them, and labels
+// each sample with the primitive and its LockClass. Only acquisitions made
+// while profiling was enabled are included.
+func WriteContentionProfile(w io.Writer) error {
+	return contention.write(w)
+}
+
+// ResetContentionProfile discards the accumulated contention, including the
+// counts in the chansync.contention profile.
+func ResetContentionProfile() {
+	contention.reset()
+}
+
+// contention accumulates the samples of WriteContentionProfile and the
+// chansync.contention profile
+var contention = newContentionStore()
+
+type contentionStore struct {
+	// mu guards the fields below, and adding to and removing from the
+	// chansync.contention profile
+	mu mutex
+	start time.Time
+	samples map[string]*contentionSample
+	// entries is the number of entries added to the chansync.contention
+	// profile, and removed is the number of them removed by reset
+	entries, removed uint64
+}
+
+type contentionSample struct {
+	name string
+	class string
+	pcs []uintptr
+
+	contentions, delay int64
+	holds, held int64
+
+	// waitOwed and holdOwed are the waiting and holding that have not yet
+	// been counted in the chansync.contention profile
+	waitOwed, holdOwed time.Duration
+}
+
+func newContentionStore() *contentionStore {
+	return &contentionStore{
+		mu: newMutex(),
+		start: time.Now(),
+		samples: map[string]*contentionSample{},
+	}
+}
+
+func (s *contentionStore) reset() {
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	s.start = time.Now()
+	s.samples = map[string]*contentionSample{}
+	for ; s.removed < s.entries; s.removed++ {
+		contentionProfile.Remove(contentionEntry(s.removed + 1))
+	}
+}
+
+// sample returns the sample of the acquisition site a. The caller must hold
+// s.mu.
+func (s *contentionStore) sample(a *site) *contentionSample {
+	key := make([]byte, 0, len(a.name) + 1 + 16*len(a.pcs))
+	key = append(key, a.name...)
+	key = append(key, 0)
+	for _, pc := range a.pcs {
+		key = strconv.AppendUint(key, uint64(pc), 16)
+		key = append(key, ',')
+	}
+
+	x := s.samples[string(key)]
+	if x == nil {
+		x = &contentionSample{name: a.name, class: a.name, pcs: a.pcs}
+		if a.class != nil {
+			x.class = a.class.String()
+		}
+		s.samples[string(key)] = x
+	}
+	return x
+}
+
+// wait accounts the wait of w. wait must be called by the goroutine that
+// waited.
+func (s *contentionStore) wait(w *waitRecord) {
+	d := time.Since(w.blocked)
+
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	x := s.sample(&w.site)
+	x.contentions++
+	x.delay += int64(d)
+	x.waitOwed += d
+	s.chargeWait(&x.waitOwed)
+}
+
+// acquire counts the holding owed by earlier acquisitions from the site of h.
+// acquire must be called by the goroutine that made the acquisition.
+func (s *contentionStore) acquire(h *holdRecord) {
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	x := s.sample(&h.site)
+	s.chargeHold(&x.holdOwed)
+}
+
+// hold accounts the hold of h. The holding is counted in the
+// chansync.contention profile by a later acquire, since h may be released by
+// a goroutine other than the one that acquired it.
+func (s *contentionStore) hold(h *holdRecord) {
+	d := time.Since(h.start)
+
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	x := s.sample(&h.site)
+	x.holds++
+	x.held += int64(d)
+	x.holdOwed += d
+}
+
+// chargeWait counts the whole quanta of owed as waiting. The caller must hold
+// s.mu.
+func (s *contentionStore) chargeWait(owed *time.Duration) {
+	// skip charge, so that the stack starts at chargeWait
+	s.charge(owed, 1)
+}
+
+// chargeHold counts the whole quanta of owed as holding. The caller must hold
+// s.mu.
+func (s *contentionStore) chargeHold(owed *time.Duration) {
+	// skip charge, so that the stack starts at chargeHold
+	s.charge(owed, 1)
+}
+
+// charge adds an entry to the chansync.contention profile for each whole
+// quantum of owed, with the calling goroutine's stack, skipping the specified
+// number of frames as pprof.Profile.Add does, and subtracts them from owed.
+// The caller must hold s.mu.
+func (s *contentionStore) charge(owed *time.Duration, skip int) {
+	for ; *owed >= contentionQuantum; *owed -= contentionQuantum {
+		s.entries++
+		contentionProfile.Add(contentionEntry(s.entries), skip + 1)
+	}
+}
+
+func (s *contentionStore) write(w io.Writer) error {
+	s.mu.lock()
+	start := s.start
+	samples := make([]contentionSample, 0, len(s.samples))
+	for _, x := range s.samples {
+		samples = append(samples, *x)
+	}
+	s.mu.unlock()
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].delay > samples[j].delay
+	})
+
+	p := newProfileBuilder()
+	for _, t := range [][2]string{{"contentions", "count"}, {"delay", "nanoseconds"}, {"holds", "count"}, {"hold", "nanoseconds"}} {
+		p.valueType(1, t[0], t[1])
+	}
+	for _, x := range samples {
+		p.sample(x.pcs, []int64{x.contentions, x.delay, x.holds, x.held}, [][2]string{{"primitive", x.name}, {"class", x.class}})
+	}
+	now := time.Now()
+	p.b.int64(9, start.UnixNano())
+	p.b.int64(10, int64(now.Sub(start)))
+	p.valueType(11, "delay", "nanoseconds")
+	p.b.int64(12, 1)
+	p.b.int64(14, p.str("delay"))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(p.finish()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// profileBuilder encodes a profile.proto message, as defined by
+// github.com/google/pprof/proto/profile.proto.
+type profileBuilder struct {
+	b protobuf
+	strings []string
+	stringIDs map[string]int64
+
+	locations map[uintptr]uint64
+	functions map[string]uint64
+	funcs protobuf
+	locs protobuf
+}
+
+func newProfileBuilder() *profileBuilder {
+	return &profileBuilder{
+		strings: []string{""},
+		stringIDs: map[string]int64{"": 0},
+		locations: map[uintptr]uint64{},
+		functions: map[string]uint64{},
+	}
+}
+
+func (p *profileBuilder) str(s string) int64 {
+	if id, ok := p.stringIDs[s]; ok {
+		return id
+	}
+	id := int64(len(p.strings))
+	p.strings = append(p.strings, s)
+	p.stringIDs[s] = id
+	return id
+}
+
+// valueType encodes a ValueType message as the specified field.
+func (p *profileBuilder) valueType(field int, typ, unit string) {
+	p.b.message(field, func(b *protobuf) {
+		b.int64(1, p.str(typ))
+		b.int64(2, p.str(unit))
+	})
+}
+
+func (p *profileBuilder) sample(pcs []uintptr, values []int64, labels [][2]string) {
+	ids := make([]uint64, 0, len(pcs))
+	for _, pc := range pcs {
+		if id := p.location(pc); id != 0 {
+			ids = append(ids, id)
+		}
+	}
+
+	p.b.message(2, func(b *protobuf) {
+		b.uint64s(1, ids)
+		b.int64s(2, values)
+		for _, l := range labels {
+			b.message(3, func(b *protobuf) {
+				b.int64(1, p.str(l[0]))
+				b.int64(2, p.str(l[1]))
+			})
+		}
+	})
+}
+
+// location returns the ID of the Location message for the return address pc,
+// encoding the message and its functions if this is the first time pc is
+// seen.
+func (p *profileBuilder) location(pc uintptr) uint64 {
+	if id, ok := p.locations[pc]; ok {
+		return id
+	}
+
+	// pc is a return address, so pc-1 is within the call instruction
+	frames := runtime.CallersFrames([]uintptr{pc})
+	type line struct {
+		function uint64
+		line int64
+	}
+	var lines []line
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			lines = append(lines, line{p.function(f), int64(f.Line)})
+		}
+		if !more {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		p.locations[pc] = 0
+		return 0
+	}
+
+	id := uint64(len(p.locations) + 1)
+	p.locations[pc] = id
+	p.locs.message(4, func(b *protobuf) {
+		b.uint64(1, id)
+		b.uint64(3, uint64(pc - 1))
+		for _, l := range lines {
+			b.message(4, func(b *protobuf) {
+				b.uint64(1, l.function)
+				b.int64(2, l.line)
+			})
+		}
+	})
+	return id
+}
+
+func (p *profileBuilder) function(f runtime.Frame) uint64 {
+	if id, ok := p.functions[f.Function]; ok {
+		return id
+	}
+
+	id := uint64(len(p.functions) + 1)
+	p.functions[f.Function] = id
+	p.funcs.message(5, func(b *protobuf) {
+		b.uint64(1, id)
+		b.int64(2, p.str(f.Function))
+		b.int64(3, p.str(f.Function))
+		b.int64(4, p.str(f.File))
+	})
+	return id
+}
+
+func (p *profileBuilder) finish() []byte {
+	p.b.data = append(p.b.data, p.locs.data...)
+	p.b.data = append(p.b.data, p.funcs.data...)
+	for _, s := range p.strings {
+		p.b.string(6, s)
+	}
+	return p.b.data
+}
+
+// protobuf is a minimal protocol buffer encoder.
+type protobuf struct {
+	data []byte
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x) | 0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protobuf) key(field, wire int) {
+	b.varint(uint64(field) << 3 | uint64(wire))
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.key(field, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protobuf) bytes(field int, x []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(x)))
+	b.data = append(b.data, x...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.key(field, 2)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+// uint64s encodes a packed repeated field.
+func (b *protobuf) uint64s(field int, xs []uint64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytes(field, packed.data)
+}
+
+// int64s encodes a packed repeated field.
+func (b *protobuf) int64s(field int, xs []int64) {
+	var packed protobuf
+	for _, x := range xs {
+		packed.varint(uint64(x))
+	}
+	b.bytes(field, packed.data)
+}
+
+// message encodes the message written by f as the specified field.
+func (b *protobuf) message(field int, f func(*protobuf)) {
+	var m protobuf
+	f(&m)
+	b.bytes(field, m.data)
+}
@@ -0,0 +1,147 @@
+package chansync
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+// holdLockFor acquires l, and holds it for d.
+func holdLockFor(l Lock, d time.Duration) {
+	u := l.Acquire()
+	time.Sleep(d)
+	u.Release()
+}
+
+// profiledCount returns the sum of the counts of the chansync.contention
+// profile whose stacks contain each of funcs.
+func profiledCount(t *testing.T, funcs ...string) int {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := pprof.Lookup("chansync.contention").WriteTo(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// with debug=1, each stack is a "count @ pcs" line followed by a line per
+	// frame, and stacks are separated by blank lines
+	total := 0
+	for _, stack := range strings.Split(buf.String(), "\n\n") {
+		var n int
+		lines := strings.Split(stack, "\n")
+		for len(lines) > 0 {
+			if _, err := fmt.Sscanf(lines[0], "%d @", &n); err == nil {
+				break
+			}
+			lines = lines[1:]
+		}
+		frames := strings.Join(lines, "\n")
+
+		all := len(lines) > 0
+		for _, f := range funcs {
+			all = all && strings.Contains(frames, f)
+		}
+		if all {
+			total += n
+		}
+	}
+	return total
+}
+
+func TestContentionProfile(t *testing.T) {
+	if pprof.Lookup("chansync.contention") == nil {
+		t.Fatal("the chansync.contention profile is not registered")
+	}
+
+	defer SetContentionProfiling(SetContentionProfiling(true))
+	ResetContentionProfile()
+	defer ResetContentionProfile()
+
+	// the holding of the first two calls is counted by the third, which
+	// acquires from the same stack
+	l := NewLock()
+	for i := 0; i < 3; i++ {
+		holdLockFor(l, 25 * time.Millisecond)
+	}
+	if n := profiledCount(t, "chargeHold", "holdLockFor", "TestContentionProfile"); n < 5 {
+		t.Errorf("got %d counts of holding by holdLockFor, want at least 5", n)
+	}
+
+	u := l.Acquire()
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		close(started)
+		l.Acquire().Release()
+		close(done)
+	}()
+	<- started
+	time.Sleep(50 * time.Millisecond)
+	u.Release()
+	<- done
+	if n := profiledCount(t, "chargeWait", "TestContentionProfile.func"); n < 1 {
+		t.Errorf("got %d counts of waiting by the waiter, want at least 1", n)
+	}
+
+	ResetContentionProfile()
+	if n := pprof.Lookup("chansync.contention").Count(); n != 0 {
+		t.Errorf("Count() = %d after ResetContentionProfile, want 0", n)
+	}
+}
+
+func TestWriteContentionProfile(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool pprof is not available")
+	}
+
+	defer SetContentionProfiling(SetContentionProfiling(true))
+	ResetContentionProfile()
+
+	l := NewLock()
+	SetLockClass(l, NewLockClass("profiled"))
+	u := l.Acquire()
+	done := make(chan struct{})
+	go func() {
+		l.Acquire().Release()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	u.Release()
+	<- done
+
+	path := filepath.Join(t.TempDir(), "contention.pb.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteContentionProfile(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// pprof parses the profile with the reference decoder, and prints it
+	out, err := exec.Command(gobin, "tool", "pprof", "-raw", path).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool pprof: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"PeriodType: delay nanoseconds",
+		"contentions/count delay/nanoseconds[dflt] holds/count hold/nanoseconds",
+		"primitive:[Lock(",
+		"class:[profiled]",
+		"TestWriteContentionProfile",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("the profile does not contain %q:\n%s", want, out)
+		}
+	}
+}
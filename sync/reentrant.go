@@ -0,0 +1,149 @@
+package chansync
+
+import (
+	"context"
+	"time"
+)
+
+// ReentrantLock is a lock that can be acquired again by the owner that holds
+// it. Every acquisition names an owner, which may be any comparable value other
+// than nil; acquisitions by the holding owner succeed immediately and increase
+// the hold count. Each acquisition returns its own Unlock, and the lock is
+// released once every one of them has been released.
+//
+// The owner is what makes an acquisition reentrant, so an owner must not be
+// shared by code that expects mutual exclusion from itself.
+type ReentrantLock interface {
+	// Acquire will block until the lock can be acquired by owner. Acquire
+	// returns a reference that can be used to release this acquisition.
+	Acquire(owner any) Unlock
+	// AcquireContext blocks until the lock can be acquired by owner or ctx
+	// is done. If ctx is done first, AcquireContext returns (nil, ctx.Err()).
+	// Otherwise AcquireContext returns (u, nil) where u is a reference that
+	// can be used to release this acquisition.
+	AcquireContext(ctx context.Context, owner any) (Unlock, error)
+	// TryAcquire attempts to acquire the lock for owner. If the lock cannot
+	// be acquired, TryAcquire returns (nil, false). Otherwise TryAcquire
+	// returns (u, true) where u is a reference that can be used to release
+	// this acquisition.
+	TryAcquire(owner any) (Unlock, bool)
+	// AcquireTimeout attempts to acquire the lock for owner, with a timeout.
+	// If the timeout expires, AcquireTimeout returns (nil,
+	// ChannelOpTimeout). Otherwise AcquireTimeout returns (u,
+	// ChannelOpSuccess) where u is a reference that can be used to release
+	// this acquisition.
+	AcquireTimeout(owner any, timeout time.Duration) (Unlock, ChannelOpResult)
+}
+
+type reentrantLock struct {
+	lock Lock
+
+	// mu guards the fields below
+	mu Lock
+	owner any
+	count int
+	unlock Unlock
+}
+
+type reentrantUnlock struct {
+	token
+	lock *reentrantLock
+}
+
+// NewReentrantLock returns a new ReentrantLock
+func NewReentrantLock() ReentrantLock {
+	return &reentrantLock{
+		lock: NewLock(),
+		mu: newInternalLock(),
+	}
+}
+
+func (l *reentrantLock) Acquire(owner any) Unlock {
+	u, _ := l.AcquireContext(context.Background(), owner)
+	return u
+}
+
+func (l *reentrantLock) AcquireContext(ctx context.Context, owner any) (Unlock, error) {
+	if u, ok := l.reenter(owner); ok {
+		return u, nil
+	}
+
+	u, err := l.lock.AcquireContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.own(owner, u), nil
+}
+
+func (l *reentrantLock) TryAcquire(owner any) (Unlock, bool) {
+	if u, ok := l.reenter(owner); ok {
+		return u, true
+	}
+
+	u, ok := l.lock.TryAcquire()
+	if !ok {
+		return nil, false
+	}
+	return l.own(owner, u), true
+}
+
+func (l *reentrantLock) AcquireTimeout(owner any, timeout time.Duration) (Unlock, ChannelOpResult) {
+	if u, ok := l.reenter(owner); ok {
+		return u, ChannelOpSuccess
+	}
+
+	u, r := l.lock.AcquireTimeout(timeout)
+	if r != ChannelOpSuccess {
+		return nil, r
+	}
+	return l.own(owner, u), ChannelOpSuccess
+}
+
+// reenter increments the hold count if the lock is held by owner.
+func (l *reentrantLock) reenter(owner any) (Unlock, bool) {
+	if owner == nil {
+		panic("ReentrantLock owner must not be nil")
+	}
+
+	m := l.mu.Acquire()
+	defer m.Release()
+
+	if l.count == 0 || l.owner != owner {
+		return nil, false
+	}
+
+	l.count++
+	return &reentrantUnlock{lock: l}, true
+}
+
+// own records owner as the holder of the freshly acquired lock.
+func (l *reentrantLock) own(owner any, u Unlock) Unlock {
+	m := l.mu.Acquire()
+	defer m.Release()
+
+	l.owner = owner
+	l.count = 1
+	l.unlock = u
+	return &reentrantUnlock{lock: l}
+}
+
+func (u *reentrantUnlock) Release() {
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+
+	l := u.lock
+	m := l.mu.Acquire()
+	defer m.Release()
+
+	l.count--
+	if l.count > 0 {
+		return
+	}
+
+	// the last hold was released, so release the underlying lock
+	unlock := l.unlock
+	l.owner = nil
+	l.unlock = nil
+	unlock.Release()
+}
@@ -0,0 +1,104 @@
+package chansync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReentrantHoldCount(t *testing.T) {
+	l := NewReentrantLock()
+
+	var held []Unlock
+	held = append(held, l.Acquire("a"))
+	held = append(held, l.Acquire("a"))
+	u, ok := l.TryAcquire("a")
+	if !ok {
+		t.Fatal("TryAcquire failed for the owner")
+	}
+	held = append(held, u)
+	u, r := l.AcquireTimeout("a", time.Millisecond)
+	if r != ChannelOpSuccess {
+		t.Fatalf("AcquireTimeout() = %v for the owner, want %v", r, ChannelOpSuccess)
+	}
+	held = append(held, u)
+
+	// the lock stays held until the last of the four holds is released
+	for len(held) > 0 {
+		if _, ok := l.TryAcquire("b"); ok {
+			t.Fatalf("TryAcquire succeeded for another owner with %d holds left", len(held))
+		}
+		held[0].Release()
+		held = held[1:]
+	}
+
+	u, ok = l.TryAcquire("b")
+	if !ok {
+		t.Fatal("TryAcquire failed for another owner once every hold was released")
+	}
+	u.Release()
+}
+
+func TestReentrantOtherOwnerBlocks(t *testing.T) {
+	l := NewReentrantLock()
+	u := l.Acquire("a")
+
+	acquired := make(chan Unlock)
+	go func() { acquired <- l.Acquire("b") }()
+	select {
+	case <- acquired:
+		t.Fatal("another owner acquired the lock while it was held")
+	case <- time.After(20 * time.Millisecond):
+	}
+
+	u.Release()
+	select {
+	case u := <- acquired:
+		// the new owner can reenter, and the old one cannot
+		v, ok := l.TryAcquire("b")
+		if !ok {
+			t.Fatal("the new owner could not reenter")
+		}
+		if _, ok := l.TryAcquire("a"); ok {
+			t.Fatal("the old owner acquired the lock held by the new owner")
+		}
+		v.Release()
+		u.Release()
+	case <- time.After(5 * time.Second):
+		t.Fatal("another owner did not acquire the lock once it was released")
+	}
+}
+
+func TestReentrantAcquireTimeout(t *testing.T) {
+	l := NewReentrantLock()
+	u := l.Acquire("a")
+
+	if v, r := l.AcquireTimeout("b", 10 * time.Millisecond); r != ChannelOpTimeout || v != nil {
+		t.Fatalf("AcquireTimeout() = (%v, %v) for another owner, want (nil, %v)", v, r, ChannelOpTimeout)
+	}
+
+	// the timed out acquisition left the hold count alone
+	u.Release()
+	v, r := l.AcquireTimeout("b", time.Second)
+	if r != ChannelOpSuccess {
+		t.Fatalf("AcquireTimeout() = %v once the lock was released, want %v", r, ChannelOpSuccess)
+	}
+	v.Release()
+}
+
+func TestReentrantNilOwnerPanics(t *testing.T) {
+	l := NewReentrantLock()
+	for name, f := range map[string]func(){
+		"Acquire": func() { l.Acquire(nil) },
+		"TryAcquire": func() { l.TryAcquire(nil) },
+		"AcquireTimeout": func() { l.AcquireTimeout(nil, time.Millisecond) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("no panic for a nil owner")
+				}
+			}()
+			f()
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package chansync
 
 import (
+	"context"
 	"time"
 )
 
@@ -9,17 +10,25 @@ import (
 // read lock can be acquired if other read locks are active. A write lock
 // cannot be acquired if any other locks are active.
 //
-// Simultaneous calls to AcquireRead or TryAcquireRead will block each other.
-// This is to provide consistency and logical behavior.
+// Whether waiting readers or waiting writers are granted the lock first is
+// determined by the lock's RWPolicy; see NewReadWriteLockWithPolicy.
 //
-// ReadUnlock and WriteUnlock objects must be discarded after any method is
-// called. The only exception is ReadUnlock.TryPromote, if and only if it fails
-// to promote the lock.
+// ReadUnlock, UpgradableUnlock and WriteUnlock objects must be discarded after
+// any method is called. The only exceptions are the methods of ReadUnlock and
+// UpgradableUnlock that promote or upgrade the lock, if and only if they fail
+// to do so. Any other use of a discarded object is misuse, and is reported as
+// described by MisuseError.
 type ReadWriteLock interface {
 	// AcquireRead blocks until a read lock can be acquired. AcquireRead
 	// returns a ReadUnlock associated with the acquired lock.
 	AcquireRead() ReadUnlock
 
+	// AcquireReadContext blocks until a read lock can be acquired or ctx is
+	// done. If ctx is done first, AcquireReadContext returns (nil,
+	// ctx.Err()). Otherwise AcquireReadContext returns a (ReadUnlock, nil)
+	// pair. The ReadUnlock is associated with the acquired lock.
+	AcquireReadContext(ctx context.Context) (ReadUnlock, error)
+
 	// TryAcquireRead attempts to acquire a read lock. If a lock cannot be
 	// acquired, TryAcquireRead returns (nil, false). If a lock is acquired,
 	// TryAcquireRead returns a (ReadUnlock, true) pair. The ReadUnlock is
@@ -30,11 +39,42 @@ type ReadWriteLock interface {
 	// returns a WriteUnlock associated with the acquired lock.
 	AcquireWrite() WriteUnlock
 
+	// AcquireWriteContext blocks until the write lock can be acquired or ctx
+	// is done. If ctx is done first, AcquireWriteContext returns (nil,
+	// ctx.Err()). Otherwise AcquireWriteContext returns a (WriteUnlock, nil)
+	// pair. The WriteUnlock is associated with the acquired lock.
+	AcquireWriteContext(ctx context.Context) (WriteUnlock, error)
+
 	// TryAcquireWrite attempts to acquire a write lock. If the lock cannot be
 	// acquired, TryAcquireWrite returns (nil, false). If the lock is acquired,
 	// TryAcquireWrite returns a (WriteUnlock, true) pair. The WriteUnlock is
 	// associated with the acquired write.
 	TryAcquireWrite() (WriteUnlock, bool)
+
+	// AcquireUpgradable blocks until an upgradable read lock can be
+	// acquired. An upgradable read lock is a read lock that can be upgraded
+	// to the write lock without the risk of deadlock, because only one can
+	// be held at a time. It coexists with plain read locks, and is acquired
+	// as a read lock would be. Some implementations cannot upgrade, in which
+	// case UpgradableUnlock.Upgrade returns nil. AcquireUpgradable returns an
+	// UpgradableUnlock associated with the acquired lock.
+	AcquireUpgradable() UpgradableUnlock
+
+	// AcquireUpgradableContext blocks until an upgradable read lock can be
+	// acquired or ctx is done. If ctx is done first,
+	// AcquireUpgradableContext returns (nil, ctx.Err()). Otherwise
+	// AcquireUpgradableContext returns an (UpgradableUnlock, nil) pair.
+	AcquireUpgradableContext(ctx context.Context) (UpgradableUnlock, error)
+
+	// TryAcquireUpgradable attempts to acquire an upgradable read lock. If
+	// the lock cannot be acquired, TryAcquireUpgradable returns (nil,
+	// false). Otherwise TryAcquireUpgradable returns an (UpgradableUnlock,
+	// true) pair.
+	TryAcquireUpgradable() (UpgradableUnlock, bool)
+
+	// Stats returns a snapshot of the state of the lock. Stats can be called
+	// at any time, including while the caller holds the lock.
+	Stats() ReadWriteLockStats
 }
 
 // ReadUnlock represents a read lock acquired from an instance of
@@ -43,10 +83,30 @@ type ReadUnlock interface {
 	// Release releases the read lock.
 	Release()
 
-	// Promote block until the read lock can be promoted into a write lock.
-	// Promote returns a WriteUnlock associated with the promoted lock.
+	// Promote blocks until the read lock can be promoted into a write lock.
+	// Promote returns a WriteUnlock associated with the promoted lock. Two
+	// read locks that are promoted at the same time would each wait for the
+	// other to be released, so only one promotion can be pending: if another
+	// promotion or an UpgradableUnlock.Upgrade is pending, or one starts
+	// while this promotion waits, Promote fails and returns nil, and the read
+	// lock remains held, as with a failed TryPromote. The caller should
+	// release the read lock to let the other promotion proceed.
+	// PromoteContext reports this failure as ErrPromotionPending. Promote
+	// also returns nil if the lock does not support promotion; see
+	// PromoteContext.
 	Promote() WriteUnlock
 
+	// PromoteContext blocks until the read lock can be promoted into a write
+	// lock or ctx is done. If ctx is done first, PromoteContext returns (nil,
+	// ctx.Err()) and the read lock remains held, as with a failed TryPromote.
+	// If the promotion fails as described by Promote, PromoteContext returns
+	// (nil, ErrPromotionPending). If the lock does not support promotion,
+	// PromoteContext returns (nil, ErrUpgradeUnsupported) immediately, the
+	// read lock remains held, and Promote and TryPromote fail likewise,
+	// returning nil and (nil, false). Otherwise PromoteContext returns a
+	// (WriteUnlock, nil) pair.
+	PromoteContext(ctx context.Context) (WriteUnlock, error)
+
 	// TryPromote attempts to promote the read lock into a write lock. Promote
 	// returns a WriteUnlock associated with the promoted lock.
 	TryPromote() (WriteUnlock, bool)
@@ -63,190 +123,537 @@ type WriteUnlock interface {
 
 
 type rwlock struct {
-	read, write Lock
-	reads AtomicInt
-	release SyncChannel
+	classed
+	policy RWPolicy
+
+	// mu guards the fields below
+	mu mutex
+	// readers is the number of read locks held, including an upgradable
+	// read lock
+	readers int
+	// upgradable is whether or not an upgradable read lock is held
+	upgradable bool
+	// gate is held by the writer, promotion or upgrade that is next to hold
+	// the write lock; new readers do not pass a held gate
+	gate *writeClaim
+	// writer is whether or not the write lock is held
+	writer bool
+	// waitingReaders is the number of readers that are waiting
+	waitingReaders int
+	// writers is the number of writers, promotions and upgrades that are
+	// waiting
+	writers int
+	// queue orders waiting writers under RWPhaseFair
+	queue waitQueue
+	// phase is incremented whenever a write phase ends
+	phase uint64
+	// phaseReaders is the number of readers waiting for the current write
+	// phase to end, under RWPhaseFair
+	phaseReaders int
+	// waiters are the acquisitions waiting for the state to change
+	waiters waitQueue
+	// acquisitions is the number of locks acquired
+	acquisitions uint64
+	// waited is the time spent waiting by acquisitions that had to wait
+	waited time.Duration
+}
+
+// writeClaim is an acquisition of the write lock.
+type writeClaim struct {
+	rank claimRank
+	// turn is the writer's place in line under RWPhaseFair
+	turn SyncChannel
+	// lost is set when a promotion loses the gate to an upgrade
+	lost bool
+}
+
+// claimRank orders the claims on the write gate. A claim that is waiting for
+// readers to leave gives up the gate to a claim of higher rank, because it
+// cannot complete while the higher claim holds a read lock.
+type claimRank uint8
+
+const (
+	claimWrite claimRank = iota
+	claimPromote
+	claimUpgrade
+)
+
+// self returns the number of read locks held by the claimant.
+func (c *writeClaim) self() int {
+	if c.rank == claimWrite {
+		return 0
+	}
+	return 1
 }
 
 type runlock struct {
+	token
 	lock *rwlock
+	hold *holdRecord
 }
 
 type wunlock struct {
-	unlock Unlock
+	token
 	lock *rwlock
+	hold *holdRecord
 }
 
-// NewReadWriteLock returns a new read-write lock
+// NewReadWriteLock returns a new read-write lock with the RWWriterPreferring
+// policy
 func NewReadWriteLock() ReadWriteLock {
-	l := &rwlock{
-		write: NewLock(),
-		reads: NewAtomicInt(0),
-		release: NewSyncChannel(),
+	return NewReadWriteLockWithPolicy(RWWriterPreferring)
+}
+
+// NewReadWriteLockWithPolicy returns a new read-write lock with the specified
+// policy
+func NewReadWriteLockWithPolicy(p RWPolicy) ReadWriteLock {
+	switch p {
+	case RWWriterPreferring, RWReaderPreferring, RWPhaseFair:
+	default:
+		panic("Invalid read-write lock policy")
 	}
 
-	return l
+	return &rwlock{
+		policy: p,
+		mu: newMutex(),
+	}
 }
 
 func (l *rwlock) AcquireRead() ReadUnlock {
-	// see TryAcquireRead
-	u1 := l.read.Acquire()
-	defer u1.Release()
-
-	// acquire the write lock
-	u2 := l.write.Acquire()
-	// release once the read count has been updated
-	defer u2.Release()
+	u, _ := l.AcquireReadContext(context.Background())
+	return u
+}
 
-	// increment the read count
-	for !Increment(l.reads) {}
+func (l *rwlock) AcquireReadContext(ctx context.Context) (ReadUnlock, error) {
+	w := beginWait(l, "ReadWriteLock")
+	err := l.acquireRead(ctx, w)
+	h := w.end(err == nil)
+	if err != nil {
+		return nil, err
+	}
 
 	// create the read unlock
-	return l.newReadUnlock()
+	return l.newReadUnlock(h), nil
+}
+
+func (l *rwlock) acquireRead(ctx context.Context, w *waitRecord) error {
+	l.mu.lock()
+	if l.tryRead() {
+		l.mu.unlock()
+		return nil
+	}
+	w.block()
+	l.waitingReaders++
+
+	if l.policy != RWPhaseFair {
+		l.mu.unlock()
+		return l.awaitReader(ctx, l.tryRead)
+	}
+
+	// wait for the current write phase to end; the writer that ends it
+	// grants the read lock
+	phase := l.phase
+	l.phaseReaders++
+	l.mu.unlock()
+
+	start := time.Now()
+	err := l.await(ctx, func() bool {
+		return l.phase != phase
+	})
+
+	l.mu.lock()
+	defer l.mu.unlock()
+	l.waitingReaders--
+	l.waited += time.Since(start)
+	if err == nil {
+		l.acquisitions++
+		return nil
+	}
+
+	if l.phase != phase {
+		// the read lock was granted while giving up
+		l.readers--
+		l.wake()
+	} else {
+		l.phaseReaders--
+	}
+	return err
+}
+
+// awaitReader waits until acquire succeeds for a reader that has been counted
+// as waiting.
+func (l *rwlock) awaitReader(ctx context.Context, acquire func() bool) error {
+	start := time.Now()
+	err := l.await(ctx, acquire)
+
+	l.mu.lock()
+	defer l.mu.unlock()
+	l.waitingReaders--
+	l.waited += time.Since(start)
+	if err != nil {
+		// a writer may have been waiting for this reader to give up
+		l.wake()
+	}
+	return err
+}
+
+// readable returns whether or not the policy lets a new reader in. The caller
+// must hold l.mu.
+func (l *rwlock) readable() bool {
+	if l.gate != nil {
+		return false
+	}
+
+	switch l.policy {
+	case RWWriterPreferring:
+		return l.writers == 0
+	case RWPhaseFair:
+		return len(l.queue) == 0 && l.phaseReaders == 0
+	}
+	return true
+}
+
+// tryRead acquires a read lock if the policy allows it. The caller must hold
+// l.mu.
+func (l *rwlock) tryRead() bool {
+	if !l.readable() {
+		return false
+	}
+	l.readers++
+	l.acquisitions++
+	return true
 }
 
 func (l *rwlock) TryAcquireRead() (ReadUnlock, bool) {
-	// l.read is only locked in [Try]AcquireRead; acquiring l.read blocks so
-	// that it's possible to differentiate between another [Try]AcquireRead
-	// owning l.write and an active write lock owning l.write; if l.read was
-	// not used or was not blocking, a TryAcquireRead call concurrent with
-	// another [Try]AcquireRead call would have a chance of false failure; a
-	// read lock acquisition should never block another read lock acquisition.
-	u1 := l.read.Acquire()
-	defer u1.Release()
-
-	// try to acquire the write lock
-	u2, ok := l.write.TryAcquire()
+	l.mu.lock()
+	ok := l.tryRead()
+	l.mu.unlock()
 	if !ok {
 		return nil, false
 	}
-	// release once the read count has been updated
-	defer u2.Release()
-
-	// increment the read count
-	for !Increment(l.reads) {}
 
 	// create the read unlock
-	return l.newReadUnlock(), true
+	return l.newReadUnlock(beginHold(l, "ReadWriteLock")), true
 }
 
 func (l *rwlock) AcquireWrite() WriteUnlock {
-	// acquire the write lock
-	u := l.write.Acquire()
+	u, _ := l.AcquireWriteContext(context.Background())
+	return u
+}
 
-	// wait until there are no active read locks
-	for {
-		if l.reads.Read() == 0 {
-			break
+func (l *rwlock) AcquireWriteContext(ctx context.Context) (WriteUnlock, error) {
+	w := beginWait(l, "ReadWriteLock")
+	err := l.acquireWrite(ctx, w, &writeClaim{rank: claimWrite})
+	h := w.end(err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the write unlock
+	return l.newWriteUnlock(h), nil
+}
+
+// acquireWrite blocks until the write lock is acquired for c, ctx is done, or
+// c is a promotion that fails.
+func (l *rwlock) acquireWrite(ctx context.Context, w *waitRecord, c *writeClaim) error {
+	l.mu.lock()
+	if c.rank == claimPromote && l.gate != nil && l.gate.rank >= claimPromote {
+		l.mu.unlock()
+		return ErrPromotionPending
+	}
+	if l.tryWrite(c) {
+		l.mu.unlock()
+		return nil
+	}
+	w.block()
+
+	if c.rank == claimWrite && l.policy == RWPhaseFair {
+		c.turn = l.queue.push()
+	}
+	l.writers++
+	l.mu.unlock()
+
+	start := time.Now()
+	err := l.await(ctx, func() bool {
+		return c.lost || l.claim(c) && l.readers == c.self()
+	})
+
+	l.mu.lock()
+	defer l.mu.unlock()
+	l.writers--
+	l.waited += time.Since(start)
+	if c.turn != nil {
+		l.queue.remove(c.turn)
+	}
+	if err == nil && c.lost {
+		err = ErrPromotionPending
+	}
+
+	if err == nil {
+		l.readers -= c.self()
+		l.writer = true
+		l.acquisitions++
+		return nil
+	}
+
+	// give up the gate, and let in the readers that waited for it
+	if l.gate == c {
+		l.gate = nil
+		l.endPhase()
+	} else if l.gate == nil && len(l.queue) == 0 {
+		l.endPhase()
+	}
+	l.wake()
+	return err
+}
+
+// claim claims the write gate for c if the policy allows it, and returns
+// whether or not c holds the gate. The caller must hold l.mu.
+func (l *rwlock) claim(c *writeClaim) bool {
+	if l.gate == c {
+		return true
+	}
+
+	if l.gate != nil {
+		if l.writer || l.gate.rank >= c.rank {
+			return false
+		}
+
+		// the holder is waiting for readers to leave, and c holds a read
+		// lock, so the holder gives way; a promotion fails, and a writer
+		// claims the gate again later
+		if l.gate.rank == claimPromote {
+			l.gate.lost = true
+			l.wake()
 		}
+		l.gate = c
+		return true
+	}
 
-		// wait for a release notification, or a timeout; if this does not
-		// have a timeout, there is a race condition with ReadUnlock.Release;
-		// in rare situations, with the right timing, Recv could deadlock here
-		l.release.TimeoutRecv(time.Millisecond)
+	if c.rank == claimWrite {
+		switch l.policy {
+		case RWReaderPreferring:
+			// readers never wait for the gate, so it can only be claimed
+			// once they are gone, and none are waiting to get in
+			if l.readers != 0 || l.waitingReaders > 0 {
+				return false
+			}
+		case RWPhaseFair:
+			if l.queue[0] != c.turn {
+				return false
+			}
+		}
 	}
 
-	// create the write unlock
-	return l.newWriteUnlock(u)
+	l.gate = c
+	return true
 }
 
-func (l *rwlock) TryAcquireWrite() (WriteUnlock, bool) {
-	// try to acquire the write lock
-	u, ok := l.write.TryAcquire()
-	if !ok {
-		return nil, false
+// tryWrite acquires the write lock for c if it is free and no writer is
+// waiting. The caller must hold l.mu.
+func (l *rwlock) tryWrite(c *writeClaim) bool {
+	if l.gate != nil || l.readers != c.self() {
+		return false
 	}
+	if c.rank == claimWrite && l.writers > 0 {
+		return false
+	}
+
+	l.readers -= c.self()
+	l.gate = c
+	l.writer = true
+	l.acquisitions++
+	return true
+}
 
-	// check if there are any active read locks
-	if l.reads.Read() != 0 {
-		u.Release()
+// endPhase ends a write phase, granting read locks to the readers that waited
+// for it. The caller must hold l.mu.
+func (l *rwlock) endPhase() {
+	l.phase++
+	l.readers += l.phaseReaders
+	l.phaseReaders = 0
+}
+
+// wake signals every waiting acquisition that the state has changed, so that
+// each checks whether it can now proceed. The caller must hold l.mu.
+func (l *rwlock) wake() {
+	for _, w := range l.waiters {
+		// each waiter is buffered and signaled at most once, so this
+		// never blocks
+		w.Send()
+	}
+	clear(l.waiters)
+	l.waiters = l.waiters[:0]
+}
+
+// await waits until acquire, which is called with l.mu held, returns true, or
+// until ctx is done. A waiter is queued under l.mu in the same critical
+// section that found it could not proceed, and every state change that could
+// let it proceed wakes it under l.mu, so a wakeup cannot be missed.
+func (l *rwlock) await(ctx context.Context, acquire func() bool) error {
+	for {
+		l.mu.lock()
+		if acquire() {
+			l.mu.unlock()
+			return nil
+		}
+		w := l.waiters.push()
+		l.mu.unlock()
+
+		select {
+		case <- w:
+		case <- ctx.Done():
+			l.mu.lock()
+			l.waiters.remove(w)
+			l.mu.unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *rwlock) TryAcquireWrite() (WriteUnlock, bool) {
+	l.mu.lock()
+	ok := l.tryWrite(&writeClaim{rank: claimWrite})
+	l.mu.unlock()
+	if !ok {
 		return nil, false
 	}
 
 	// create the write unlock
-	return l.newWriteUnlock(u), true
+	return l.newWriteUnlock(beginHold(l, "ReadWriteLock")), true
 }
 
-func (l *rwlock) newReadUnlock() ReadUnlock {
+func (l *rwlock) newReadUnlock(h *holdRecord) ReadUnlock {
 	return &runlock{
 		lock: l,
+		hold: h,
 	}
 }
 
 func (r *runlock) Promote() WriteUnlock {
-	// acquire the write lock
-	u := r.lock.write.Acquire()
+	w, _ := r.PromoteContext(context.Background())
+	return w
+}
 
-	// wait until there are no other active read locks
-	for {
-		if r.lock.reads.Read() == 1 {
-			break
-		}
+func (r *runlock) PromoteContext(ctx context.Context) (WriteUnlock, error) {
+	if err := r.consume("ReadUnlock", usePromote); err != nil {
+		return nil, err
+	}
 
-		// see AcquireWrite
-		r.lock.release.TimeoutRecv(time.Millisecond)
+	w, h, err := r.lock.upgrade(ctx, r.hold, claimPromote)
+	if err != nil {
+		r.hold = h
+		r.restore()
+		return nil, err
 	}
+	return w, nil
+}
 
-	// release this read lock
-	if !r.lock.reads.Write(1, 0) {
-		panic("ReadWriteLock has inconsistent internal state")
+// upgrade trades a read lock, held as h, for the write lock. If that fails,
+// upgrade returns the hold record of the read lock, which remains held.
+func (l *rwlock) upgrade(ctx context.Context, h *holdRecord, rank claimRank) (WriteUnlock, *holdRecord, error) {
+	// the read lock is given up while waiting as far as diagnostics are
+	// concerned, since an upgrade does not wait for its own read lock
+	h.end()
+	w := beginWait(l, "ReadWriteLock")
+	err := l.acquireWrite(ctx, w, &writeClaim{rank: rank})
+	h = w.end(err == nil)
+	if err != nil {
+		return nil, beginHold(l, "ReadWriteLock"), err
 	}
 
 	// create the write unlock
-	return r.lock.newWriteUnlock(u)
+	return l.newWriteUnlock(h), nil, nil
 }
 
 func (r *runlock) TryPromote() (WriteUnlock, bool) {
-	// try to acquire the write lock
-	u, ok := r.lock.write.TryAcquire()
-	if !ok {
+	if r.consume("ReadUnlock", useTryPromote) != nil {
 		return nil, false
 	}
 
-	// check if there are any other active read locks
-	if r.lock.reads.Read() != 1 {
-		u.Release()
-		return nil, false
+	w, ok := r.lock.tryUpgrade(r.hold, claimPromote)
+	if !ok {
+		r.restore()
 	}
+	return w, ok
+}
 
-	// release this read lock
-	if !r.lock.reads.Write(1, 0) {
-		panic("ReadWriteLock has inconsistent internal state")
+// tryUpgrade attempts to trade a read lock, held as h, for the write lock.
+func (l *rwlock) tryUpgrade(h *holdRecord, rank claimRank) (WriteUnlock, bool) {
+	l.mu.lock()
+	ok := l.tryWrite(&writeClaim{rank: rank})
+	l.mu.unlock()
+	if !ok {
+		return nil, false
 	}
+	h.end()
 
 	// create the write unlock
-	return r.lock.newWriteUnlock(u), true
+	return l.newWriteUnlock(beginHold(l, "ReadWriteLock")), true
 }
 
 func (r *runlock) Release() {
+	if r.consume("ReadUnlock", useRelease) != nil {
+		return
+	}
+
 	// release this read lock
-	for !Decrement(r.lock.reads) {}
+	r.hold.end()
+	r.lock.releaseRead()
+}
+
+func (l *rwlock) releaseRead() {
+	l.mu.lock()
+	defer l.mu.unlock()
 
-	// send a release event to a waiting write acquire
-	r.lock.release.TrySend()
+	l.readers--
+	if l.readers <= 1 {
+		// a writer waits for the last reader to leave, and a promotion
+		// for every reader but itself
+		l.wake()
+	}
 }
 
-func (l *rwlock) newWriteUnlock(u Unlock) WriteUnlock {
+func (l *rwlock) newWriteUnlock(h *holdRecord) WriteUnlock {
 	return &wunlock{
-		unlock: u,
 		lock: l,
+		hold: h,
 	}
 }
 
 func (w *wunlock) Demote() ReadUnlock {
-	// release this write lock once the read count has been updated
-	defer w.unlock.Release()
-
-	// increment the read count
-	if !w.lock.reads.Write(0, 1) {
-		panic("ReadWriteLock has inconsistent internal state")
+	if w.consume("WriteUnlock", useDemote) != nil {
+		return nil
 	}
 
+	// trade the write lock for a read lock, letting in the readers that
+	// waited for it
+	w.hold.end()
+	l := w.lock
+	l.mu.lock()
+	l.readers++
+	l.writer = false
+	l.gate = nil
+	l.endPhase()
+	l.wake()
+	l.mu.unlock()
+
 	// create the read unlock
-	return w.lock.newReadUnlock()
+	return l.newReadUnlock(beginHold(l, "ReadWriteLock"))
 }
 
 func (w *wunlock) Release() {
+	if w.consume("WriteUnlock", useRelease) != nil {
+		return
+	}
+
 	// release this write lock
-	w.unlock.Release()
-}
\ No newline at end of file
+	w.hold.end()
+	l := w.lock
+	l.mu.lock()
+	l.writer = false
+	l.gate = nil
+	l.endPhase()
+	l.wake()
+	l.mu.unlock()
+}
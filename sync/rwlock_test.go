@@ -0,0 +1,341 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var rwPolicies = map[string]RWPolicy{
+	"writer-preferring": RWWriterPreferring,
+	"reader-preferring": RWReaderPreferring,
+	"phase-fair": RWPhaseFair,
+}
+
+func TestReadWriteLockFreshRead(t *testing.T) {
+	// NewReadWriteLock once left the lock used by the read path nil, so
+	// the first AcquireRead or TryAcquireRead panicked
+	for name, p := range rwPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewReadWriteLockWithPolicy(p)
+			r := l.AcquireRead()
+			s, ok := l.TryAcquireRead()
+			if !ok {
+				t.Fatal("TryAcquireRead failed while only read locks are held")
+			}
+			s.Release()
+			r.Release()
+
+			if _, ok := NewReadWriteLockWithPolicy(p).TryAcquireRead(); !ok {
+				t.Fatal("TryAcquireRead failed on a new lock")
+			}
+		})
+	}
+}
+
+// overlapReaders keeps a read lock of l held at all times, taking the next
+// read lock before releasing the last, until stop is set. A reader that the
+// policy holds back gives up its read lock and waits its turn, so a writer
+// can only be starved by the policy itself.
+func overlapReaders(l ReadWriteLock, stop *atomic.Bool) {
+	r := l.AcquireRead()
+	for !stop.Load() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		next, err := l.AcquireReadContext(ctx)
+		cancel()
+		r.Release()
+		if err != nil {
+			next = l.AcquireRead()
+		}
+		r = next
+	}
+	r.Release()
+}
+
+func TestReadWriteLockWriterNotStarved(t *testing.T) {
+	for _, name := range []string{"writer-preferring", "phase-fair"} {
+		t.Run(name, func(t *testing.T) {
+			l := NewReadWriteLockWithPolicy(rwPolicies[name])
+
+			var stop atomic.Bool
+			done := make(chan struct{})
+			go func() {
+				overlapReaders(l, &stop)
+				close(done)
+			}()
+			defer func() {
+				stop.Store(true)
+				<- done
+			}()
+			waitUntil(t, "a reader holds the lock", func() bool { return l.Stats().Readers > 0 })
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+			defer cancel()
+			w, err := l.AcquireWriteContext(ctx)
+			if err != nil {
+				t.Fatalf("the writer was starved: %v", err)
+			}
+			w.Release()
+		})
+	}
+}
+
+func TestReadWriteLockReaderPreferringStarvesWriter(t *testing.T) {
+	l := NewReadWriteLockWithPolicy(RWReaderPreferring)
+
+	var stop atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		overlapReaders(l, &stop)
+		close(done)
+	}()
+	waitUntil(t, "a reader holds the lock", func() bool { return l.Stats().Readers > 0 })
+
+	// overlapping readers are always let in, so the writer never is
+	ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+	defer cancel()
+	if w, err := l.AcquireWriteContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		if err == nil {
+			w.Release()
+		}
+		t.Errorf("AcquireWriteContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	stop.Store(true)
+	<- done
+	w, ok := l.TryAcquireWrite()
+	if !ok {
+		t.Fatal("TryAcquireWrite failed once the readers stopped")
+	}
+	w.Release()
+}
+
+func TestReadWriteLockWakesWriter(t *testing.T) {
+	l := NewReadWriteLock()
+	for i := 0; i < 1000; i++ {
+		// release the last read lock while the writer may be anywhere
+		// between finding it held and waiting, so a wakeup that is lost
+		// in between hangs the writer
+		r := l.AcquireRead()
+		acquired := make(chan WriteUnlock)
+		go func() { acquired <- l.AcquireWrite() }()
+		if i % 2 == 0 {
+			waitUntil(t, "the writer waits", func() bool { return l.Stats().WaitingWriters == 1 })
+		}
+		r.Release()
+
+		select {
+		case w := <- acquired:
+			w.Release()
+		case <- time.After(5 * time.Second):
+			t.Fatalf("iteration %d: the writer was not woken by the last reader", i)
+		}
+	}
+}
+
+func TestReadWriteLockWakesPromotion(t *testing.T) {
+	l := NewReadWriteLock()
+	for i := 0; i < 1000; i++ {
+		r := l.AcquireRead()
+		other := l.AcquireRead()
+		promoted := make(chan WriteUnlock)
+		go func() {
+			promoted <- r.Promote()
+		}()
+		other.Release()
+
+		select {
+		case w := <- promoted:
+			if w == nil {
+				t.Fatalf("iteration %d: the promotion failed", i)
+			}
+			w.Release()
+		case <- time.After(5 * time.Second):
+			t.Fatalf("iteration %d: the promotion was not woken by the other reader", i)
+		}
+	}
+}
+
+// benchmarkWriterWakeup measures the time from the release of the last read
+// lock to the acquisition of the write lock by a waiting writer. acquire
+// acquires the write lock, and closes waiting once it has to wait.
+func benchmarkWriterWakeup(b *testing.B, acquire func(l ReadWriteLock, waiting chan<- struct{}) WriteUnlock) {
+	var total time.Duration
+	for i := 0; i < b.N; i++ {
+		l := NewReadWriteLock()
+		r := l.AcquireRead()
+
+		waiting := make(chan struct{})
+		woke := make(chan time.Time)
+		go func() {
+			w := acquire(l, waiting)
+			woke <- time.Now()
+			w.Release()
+		}()
+		<- waiting
+
+		start := time.Now()
+		r.Release()
+		total += (<- woke).Sub(start)
+	}
+	b.ReportMetric(float64(total.Nanoseconds()) / float64(b.N), "ns/wake")
+}
+
+func BenchmarkReadWriteLockWriterWakeup(b *testing.B) {
+	b.Run("event", func(b *testing.B) {
+		benchmarkWriterWakeup(b, func(l ReadWriteLock, waiting chan<- struct{}) WriteUnlock {
+			go func() {
+				for l.Stats().WaitingWriters == 0 {
+					time.Sleep(time.Microsecond)
+				}
+				close(waiting)
+			}()
+			return l.AcquireWrite()
+		})
+	})
+
+	// poll is how waiters waited before they were woken by events: the
+	// state was checked again every millisecond
+	b.Run("poll", func(b *testing.B) {
+		benchmarkWriterWakeup(b, func(l ReadWriteLock, waiting chan<- struct{}) WriteUnlock {
+			idle := NewSyncChannel()
+			for first := true; ; first = false {
+				if w, ok := l.TryAcquireWrite(); ok {
+					return w
+				}
+				if first {
+					close(waiting)
+				}
+				idle.TimeoutRecv(time.Millisecond)
+			}
+		})
+	})
+}
+
+func TestReadWriteLockConcurrentPromote(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		l := NewReadWriteLock()
+		a, b := l.AcquireRead(), l.AcquireRead()
+
+		// each promotion waits for the other reader, so one must fail and
+		// give up its read lock for the other to proceed
+		promote := func(r ReadUnlock, failed chan<- bool) {
+			w := r.Promote()
+			if w == nil {
+				r.Release()
+			} else {
+				w.Release()
+			}
+			failed <- w == nil
+		}
+		results := make(chan bool, 2)
+		go promote(a, results)
+		go promote(b, results)
+
+		fails := 0
+		for j := 0; j < 2; j++ {
+			select {
+			case failed := <- results:
+				if failed {
+					fails++
+				}
+			case <- time.After(5 * time.Second):
+				t.Fatal("concurrent promotions deadlocked")
+			}
+		}
+		if fails != 1 {
+			t.Fatalf("%d of the promotions failed, want 1", fails)
+		}
+	}
+}
+
+func TestReadWriteLockPromotionPending(t *testing.T) {
+	l := NewReadWriteLock()
+	a, b := l.AcquireRead(), l.AcquireRead()
+
+	promoted := make(chan WriteUnlock)
+	go func() { promoted <- a.Promote() }()
+	waitUntil(t, "the promotion waits", func() bool { return l.Stats().WaitingWriters == 1 })
+
+	if _, err := b.PromoteContext(context.Background()); !errors.Is(err, ErrPromotionPending) {
+		t.Fatalf("PromoteContext() = %v, want %v", err, ErrPromotionPending)
+	}
+	if _, ok := b.TryPromote(); ok {
+		t.Fatal("TryPromote succeeded while another promotion is pending")
+	}
+
+	// the failed promotions left the read lock held and usable
+	b.Release()
+	w := <- promoted
+	if w == nil {
+		t.Fatal("the pending promotion failed")
+	}
+	w.Release()
+}
+
+func TestReadWriteLockContext(t *testing.T) {
+	for name, p := range rwPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewReadWriteLockWithPolicy(p)
+
+			w := l.AcquireWrite()
+			ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+			defer cancel()
+			if r, err := l.AcquireReadContext(ctx); r != nil || !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("AcquireReadContext() = (%v, %v) after the deadline, want (nil, %v)", r, err, context.DeadlineExceeded)
+			}
+			if x, err := l.AcquireWriteContext(canceledContext()); x != nil || !errors.Is(err, context.Canceled) {
+				t.Errorf("AcquireWriteContext() = (%v, %v) when canceled, want (nil, %v)", x, err, context.Canceled)
+			}
+			w.Release()
+
+			// neither acquisition is left waiting or holding the lock
+			s := l.Stats()
+			if s.WaitingReaders != 0 || s.WaitingWriters != 0 || s.Readers != 0 || s.Writer {
+				t.Fatalf("Stats() = %+v after the acquisitions gave up", s)
+			}
+			x, ok := l.TryAcquireWrite()
+			if !ok {
+				t.Fatal("the write lock could not be acquired after the acquisitions gave up")
+			}
+			x.Release()
+		})
+	}
+}
+
+func TestReadWriteLockPromoteContext(t *testing.T) {
+	for name, p := range rwPolicies {
+		t.Run(name, func(t *testing.T) {
+			l := NewReadWriteLockWithPolicy(p)
+			other := l.AcquireRead()
+			r := l.AcquireRead()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+			defer cancel()
+			if w, err := r.PromoteContext(ctx); w != nil || !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("PromoteContext() = (%v, %v) after the deadline, want (nil, %v)", w, err, context.DeadlineExceeded)
+			}
+			if w, err := r.PromoteContext(canceledContext()); w != nil || !errors.Is(err, context.Canceled) {
+				t.Fatalf("PromoteContext() = (%v, %v) when canceled, want (nil, %v)", w, err, context.Canceled)
+			}
+
+			// the read lock was restored, and is the only thing left
+			s := l.Stats()
+			if s.Readers != 2 || s.WaitingWriters != 0 || s.Writer {
+				t.Fatalf("Stats() = %+v after the promotions gave up, want 2 readers", s)
+			}
+			if _, ok := l.TryAcquireWrite(); ok {
+				t.Fatal("the write lock was acquired while the restored read lock is held")
+			}
+
+			other.Release()
+			w, err := r.PromoteContext(context.Background())
+			if err != nil {
+				t.Fatalf("PromoteContext() = %v once the other reader released", err)
+			}
+			w.Release()
+		})
+	}
+}
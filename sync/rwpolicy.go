@@ -0,0 +1,28 @@
+package chansync
+
+// RWPolicy determines whether a ReadWriteLock grants waiting readers or
+// waiting writers first.
+type RWPolicy uint8
+
+const (
+	// RWWriterPreferring blocks new readers while any writer is waiting, so
+	// a writer only waits for the readers that were already active. A
+	// steady stream of writers can starve readers. This is the policy used
+	// by NewReadWriteLock.
+	RWWriterPreferring RWPolicy = iota
+
+	// RWReaderPreferring lets readers in whenever the write lock is not
+	// held, so a writer waits until there are no readers at all. This
+	// maximizes read throughput, but overlapping readers can starve writers.
+	RWReaderPreferring
+
+	// RWPhaseFair alternates between read phases and write phases. Writers
+	// are granted the lock one at a time, in the order they called. A reader
+	// that arrives while a writer is waiting or active waits for that writer
+	// only, and is let in when its write phase ends, together with every
+	// other reader that waited for it and ahead of the next writer. Neither
+	// readers nor writers can be starved: a reader waits for at most one
+	// write phase, and a writer waits for at most one read phase per writer
+	// ahead of it.
+	RWPhaseFair
+)
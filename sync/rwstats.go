@@ -0,0 +1,40 @@
+package chansync
+
+import "time"
+
+// ReadWriteLockStats is a snapshot of the state of a ReadWriteLock, as
+// returned by ReadWriteLock.Stats.
+type ReadWriteLockStats struct {
+	// Readers is the number of read locks held, including an upgradable
+	// read lock.
+	Readers int
+	// Writer is whether or not the write lock is held.
+	Writer bool
+	// WaitingReaders is the number of acquisitions of read locks and
+	// upgradable read locks that are waiting.
+	WaitingReaders int
+	// WaitingWriters is the number of acquisitions of the write lock that are
+	// waiting, including promotions and upgrades.
+	WaitingWriters int
+	// Acquisitions is the number of locks acquired since the lock was
+	// created, including promotions and upgrades. Demoting or downgrading a
+	// lock is not an acquisition.
+	Acquisitions uint64
+	// WaitTime is the total time spent waiting by acquisitions that could not
+	// be made immediately, including acquisitions that gave up.
+	WaitTime time.Duration
+}
+
+func (l *rwlock) Stats() ReadWriteLockStats {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	return ReadWriteLockStats{
+		Readers: l.readers,
+		Writer: l.writer,
+		WaitingReaders: l.waitingReaders,
+		WaitingWriters: l.writers,
+		Acquisitions: l.acquisitions,
+		WaitTime: l.waited,
+	}
+}
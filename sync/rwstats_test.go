@@ -0,0 +1,83 @@
+package chansync
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadWriteLockStats(t *testing.T) {
+	l := NewReadWriteLock()
+	if s := l.Stats(); s != (ReadWriteLockStats{}) {
+		t.Fatalf("Stats() = %+v for a new lock, want zero", s)
+	}
+
+	r1, r2 := l.AcquireRead(), l.AcquireRead()
+	u := l.AcquireUpgradable()
+	if s := l.Stats(); s.Readers != 3 || s.Writer || s.Acquisitions != 3 {
+		t.Fatalf("Stats() = %+v, want 3 readers and 3 acquisitions", s)
+	}
+
+	// a writer waits for the readers, and under RWWriterPreferring a new
+	// reader waits for the writer
+	wrote := make(chan struct{})
+	go func() {
+		l.AcquireWrite().Release()
+		close(wrote)
+	}()
+	waitUntil(t, "the writer waits", func() bool { return l.Stats().WaitingWriters == 1 })
+	read := make(chan struct{})
+	go func() {
+		l.AcquireRead().Release()
+		close(read)
+	}()
+	waitUntil(t, "the reader waits", func() bool { return l.Stats().WaitingReaders == 1 })
+
+	time.Sleep(10 * time.Millisecond)
+	r1.Release()
+	r2.Release()
+	u.Release()
+	<- wrote
+	<- read
+
+	s := l.Stats()
+	if s.Readers != 0 || s.Writer || s.WaitingReaders != 0 || s.WaitingWriters != 0 {
+		t.Errorf("Stats() = %+v once every lock is released, want no locks or waiters", s)
+	}
+	if s.Acquisitions != 5 {
+		t.Errorf("Acquisitions = %d, want 5", s.Acquisitions)
+	}
+	// both waited at least 10ms
+	if s.WaitTime < 20 * time.Millisecond {
+		t.Errorf("WaitTime = %v, want at least 20ms", s.WaitTime)
+	}
+}
+
+func TestReadWriteLockStatsWriter(t *testing.T) {
+	l := NewReadWriteLock()
+
+	w := l.AcquireWrite()
+	if s := l.Stats(); !s.Writer || s.Readers != 0 {
+		t.Fatalf("Stats() = %+v, want the writer and no readers", s)
+	}
+
+	// demoting is not an acquisition, and giving up still counts the wait
+	r := w.Demote()
+	if s := l.Stats(); s.Writer || s.Readers != 1 || s.Acquisitions != 1 {
+		t.Fatalf("Stats() = %+v after Demote, want 1 reader and 1 acquisition", s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := l.AcquireWriteContext(ctx); err == nil {
+		t.Fatal("AcquireWriteContext succeeded while a read lock is held")
+	}
+	r.Release()
+
+	s := l.Stats()
+	if s.Acquisitions != 1 || s.WaitingWriters != 0 {
+		t.Errorf("Stats() = %+v, want 1 acquisition and no waiters", s)
+	}
+	if s.WaitTime < 10 * time.Millisecond {
+		t.Errorf("WaitTime = %v, want at least 10ms", s.WaitTime)
+	}
+}
@@ -0,0 +1,273 @@
+package chansync
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// Selector waits on several synchronization primitives at once and commits
+// exactly one of them, like a select block that also understands Lock and
+// Event. Cases are registered with the On methods and the selection is made by
+// Run or RunContext. A Selector can be run more than once.
+//
+// Lock and Event cases are waited on by helper goroutines. Once a case has
+// been chosen, the others are backed out: a Lock that was acquired by a losing
+// case is released, and a PublishOne that was delivered to a losing case is
+// passed on with another PublishOne. A PublishAll that was delivered to a
+// losing case is not passed on, since it has already woken every other
+// subscriber, and publishing it again would wake subscribers that arrived
+// after it. Run does not return until every helper goroutine has exited.
+type Selector struct {
+	cases []*selectCase
+	fallback func()
+}
+
+type selectKind uint8
+
+const (
+	selectRecv selectKind = iota
+	selectAcquire
+	selectSubscribe
+	selectTimeout
+)
+
+type selectCase struct {
+	kind selectKind
+
+	ch SyncChannel
+	lock Lock
+	event Event
+	timeout time.Duration
+
+	onAcquire func(Unlock)
+	onResult func(ChannelOpResult)
+	onTimeout func()
+}
+
+// Select returns a new, empty Selector.
+func Select() *Selector {
+	return &Selector{}
+}
+
+// OnAcquire adds a case that acquires l. If the case is chosen, fn is called
+// with the Unlock for the acquired lock.
+func (s *Selector) OnAcquire(l Lock, fn func(Unlock)) *Selector {
+	s.cases = append(s.cases, &selectCase{kind: selectAcquire, lock: l, onAcquire: fn})
+	return s
+}
+
+// OnSubscribe adds a case that subscribes to e. If the case is chosen, fn is
+// called with ChannelOpSuccess, or ChannelOpClosed if the event was destroyed.
+func (s *Selector) OnSubscribe(e Event, fn func(ChannelOpResult)) *Selector {
+	s.cases = append(s.cases, &selectCase{kind: selectSubscribe, event: e, onResult: fn})
+	return s
+}
+
+// OnRecv adds a case that receives a signal from ch. If the case is chosen, fn
+// is called with ChannelOpSuccess, or ChannelOpClosed if ch is closed.
+func (s *Selector) OnRecv(ch SyncChannel, fn func(ChannelOpResult)) *Selector {
+	s.cases = append(s.cases, &selectCase{kind: selectRecv, ch: ch, onResult: fn})
+	return s
+}
+
+// OnTimeout adds a case that is chosen if no other case is ready within the
+// specified duration. The duration is measured from the start of each run.
+func (s *Selector) OnTimeout(d time.Duration, fn func()) *Selector {
+	s.cases = append(s.cases, &selectCase{kind: selectTimeout, timeout: d, onTimeout: fn})
+	return s
+}
+
+// Default sets a function that is called if no case is ready immediately. If a
+// default is set, Run never blocks: each case is attempted once, in the order
+// it was added, and the first that is ready is chosen. Event cases are never
+// ready immediately.
+func (s *Selector) Default(fn func()) *Selector {
+	s.fallback = fn
+	return s
+}
+
+// Run blocks until one case is chosen, then calls that case's function.
+func (s *Selector) Run() {
+	s.RunContext(context.Background())
+}
+
+// RunContext blocks until one case is chosen or ctx is done. If ctx is done
+// first, every case is backed out and RunContext returns ctx.Err(). Otherwise
+// RunContext calls the chosen case's function and returns nil.
+func (s *Selector) RunContext(ctx context.Context) error {
+	var fn func()
+	if s.fallback != nil {
+		fn = s.poll()
+		if fn == nil {
+			fn = s.fallback
+		}
+	} else {
+		var err error
+		fn, err = s.wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	fn()
+	return nil
+}
+
+// poll attempts each case without blocking, returning the function to call for
+// the first case that is ready, or nil if none are.
+func (s *Selector) poll() func() {
+	for _, c := range s.cases {
+		switch c.kind {
+		case selectRecv:
+			if r := c.ch.TryRecv(); r != ChannelOpFailure {
+				return c.result(r)
+			}
+		case selectAcquire:
+			if u, ok := c.lock.TryAcquire(); ok {
+				return c.acquired(u)
+			}
+		case selectTimeout:
+			if c.timeout <= 0 {
+				return c.expired()
+			}
+		}
+	}
+	return nil
+}
+
+// wait blocks until a case is chosen or ctx is done, and backs out of every
+// other case before returning.
+func (s *Selector) wait(ctx context.Context) (func(), error) {
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	won := make(chan func())
+	exited := NewSyncChannelN(len(s.cases))
+	running := 0
+
+	// the first two select cases are the context and the helper
+	// goroutines; the rest are recv and timeout cases
+	sel := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(cctx.Done())},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(won)},
+	}
+	var direct []*selectCase
+
+	for _, c := range s.cases {
+		switch c.kind {
+		case selectRecv:
+			sel = append(sel, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.ch)})
+			direct = append(direct, c)
+
+		case selectTimeout:
+			t := NewTimer(c.timeout)
+			defer t.Stop()
+			sel = append(sel, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(t.C())})
+			direct = append(direct, c)
+
+		case selectAcquire:
+			running++
+			go c.waitAcquire(cctx, won, exited)
+
+		case selectSubscribe:
+			running++
+			go c.waitSubscribe(cctx, won, exited)
+		}
+	}
+
+	var fn func()
+	var err error
+
+	chosen, recv, ok := reflect.Select(sel)
+	switch chosen {
+	case 0:
+		err = ctx.Err()
+	case 1:
+		fn = recv.Interface().(func())
+	default:
+		c := direct[chosen-2]
+		if c.kind == selectTimeout {
+			fn = c.expired()
+		} else if ok {
+			fn = c.result(ChannelOpSuccess)
+		} else {
+			fn = c.result(ChannelOpClosed)
+		}
+	}
+
+	// back out of the remaining cases and wait for the helpers to finish
+	cancel()
+	for ; running > 0; running-- {
+		exited.Recv()
+	}
+
+	return fn, err
+}
+
+// waitAcquire acquires the lock and offers it to the selector. If another case
+// is chosen first, the lock is released.
+func (c *selectCase) waitAcquire(ctx context.Context, won chan<- func(), exited SyncChannel) {
+	defer exited.Send()
+
+	u, err := c.lock.AcquireContext(ctx)
+	if err != nil {
+		return
+	}
+
+	select {
+	case won <- c.acquired(u):
+	case <- ctx.Done():
+		u.Release()
+	}
+}
+
+// waitSubscribe subscribes to the event and offers the result to the
+// selector. If another case is chosen first, a PublishOne that was delivered
+// is passed on to the next subscriber, and a PublishAll is dropped.
+func (c *selectCase) waitSubscribe(ctx context.Context, won chan<- func(), exited SyncChannel) {
+	defer exited.Send()
+
+	var r ChannelOpResult
+	var one bool
+	if e, ok := c.event.(*event); ok {
+		r, one = e.subscribe(ctx.Done())
+	} else {
+		r, _ = c.event.SubscribeContext(ctx)
+	}
+	if r != ChannelOpSuccess && r != ChannelOpClosed {
+		return
+	}
+
+	select {
+	case won <- c.result(r):
+	case <- ctx.Done():
+		if one {
+			c.event.PublishOne()
+		}
+	}
+}
+
+func (c *selectCase) acquired(u Unlock) func() {
+	return func() {
+		if c.onAcquire != nil {
+			c.onAcquire(u)
+		}
+	}
+}
+
+func (c *selectCase) result(r ChannelOpResult) func() {
+	return func() {
+		if c.onResult != nil {
+			c.onResult(r)
+		}
+	}
+}
+
+func (c *selectCase) expired() func() {
+	return func() {
+		if c.onTimeout != nil {
+			c.onTimeout()
+		}
+	}
+}
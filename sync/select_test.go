@@ -0,0 +1,88 @@
+package chansync
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSelectBackOutLock(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		l := NewLock()
+		ch := NewSyncChannelN(1)
+		ch.Send()
+
+		// the recv case is ready, so the lock case loses, whether or not its
+		// helper acquired the lock first
+		var acquired bool
+		Select().
+			OnAcquire(l, func(u Unlock) { acquired = true; u.Release() }).
+			OnRecv(ch, func(ChannelOpResult) {}).
+			Run()
+		if acquired {
+			continue
+		}
+
+		if u, ok := l.TryAcquire(); !ok {
+			t.Fatal("the losing case did not release the lock")
+		} else {
+			u.Release()
+		}
+	}
+}
+
+func TestSelectBackOutPublishOne(t *testing.T) {
+	// a subscriber blocked in subscribe is subscribed once it is no longer
+	// blocked in newSub
+	subscribed := func() int {
+		return goroutinesBlocked("select", "(*event).subscribe(") - goroutinesBlocked("select", "(*event).newSub(")
+	}
+
+	for i := 0; i < 100; i++ {
+		e := NewEvent()
+		before := subscribed()
+		ch := NewSyncChannelN(1)
+
+		chose := make(chan bool)
+		go func() {
+			var event bool
+			Select().
+				OnSubscribe(e, func(ChannelOpResult) { event = true }).
+				OnRecv(ch, func(ChannelOpResult) {}).
+				Run()
+			chose <- event
+		}()
+
+		// wait for the event case to subscribe, then subscribe another
+		// subscriber; newSub returns once it is subscribed, so the
+		// PublishOne always has a subscriber to go to, and the event case
+		// has one to pass it on to
+		waitUntil(t, "the event case is subscribed", func() bool { return subscribed() > before })
+		sub := e.(*event).newSub()
+		woken := make(chan struct{})
+		go func() {
+			<- sub
+			close(woken)
+		}()
+
+		// race the publish with the recv case
+		ch.Send()
+		e.PublishOne()
+
+		// the PublishOne wakes exactly one subscriber: either the event
+		// case won, or it was passed on to the other subscriber
+		if <- chose {
+			select {
+			case <- woken:
+				t.Fatal("the PublishOne was delivered twice")
+			case <- time.After(10 * time.Millisecond):
+			}
+		} else {
+			select {
+			case <- woken:
+			case <- time.After(5 * time.Second):
+				t.Fatal("the PublishOne was lost by the losing case")
+			}
+		}
+		e.Destroy()
+	}
+}
@@ -1,78 +1,335 @@
 package chansync
 
 import (
-	"time"
+	"context"
+	"fmt"
 )
 
-// Semaphore is a semaphore driven by channels. Nothing special here.
+// Semaphore is a semaphore driven by channels. Acquiring resources returns an
+// Unsemaphore, and only that Unsemaphore can release them, so resources cannot
+// be released by goroutines that do not hold them, or released more than once.
+//
+// Acquisitions that have to wait are served in first-in, first-out order. An
+// acquisition of many resources holds back the smaller ones queued behind it,
+// rather than being starved by them.
 type Semaphore interface {
-	// Acquire blocks until the specified number of resources are obtained.
-	Acquire(n int)
-	// TryAcquire attempts to acquire the specified number of resources. If
-	// the operation fails, TryAcquire returns false. Otherwise, TryAcquire
-	// returns true.
-	TryAcquire(n int) bool
-	// Release releases the specified number of resources.
+	// Count returns the total number of resources.
+	Count() int
+	// Available returns the number of resources that are not held.
+	Available() int
+
+	// Acquire blocks until the specified number of resources are obtained,
+	// and every acquisition that was waiting before it has been served.
+	// Acquire returns an Unsemaphore that can be used to release them.
+	Acquire(n int) Unsemaphore
+	// AcquireContext blocks until the specified number of resources are
+	// obtained or ctx is done. If ctx is done first, AcquireContext returns
+	// (nil, ctx.Err()) and no resources are obtained. Otherwise
+	// AcquireContext returns (u, nil) where u is an Unsemaphore that can be
+	// used to release them.
+	AcquireContext(ctx context.Context, n int) (Unsemaphore, error)
+	// TryAcquire attempts to acquire the specified number of resources. It
+	// fails if too few are available, or if any acquisition is waiting. If
+	// the operation fails, TryAcquire returns (nil, false). Otherwise,
+	// TryAcquire returns (u, true) where u is an Unsemaphore that can be used
+	// to release them.
+	TryAcquire(n int) (Unsemaphore, bool)
+	// TryAcquireErr is TryAcquire, reporting failure as ErrWouldBlock.
+	TryAcquireErr(n int) (Unsemaphore, error)
+
+	// AcquireOne is Acquire(1).
+	AcquireOne() Unsemaphore
+	// TryAcquireOne is TryAcquire(1).
+	TryAcquireOne() (Unsemaphore, bool)
+}
+
+// Unsemaphore represents resources acquired from an instance of Semaphore.
+// The resources can be released all at once, or a few at a time. Once every
+// resource has been released, the Unsemaphore must be discarded, and any
+// further call to Release or ReleaseAll is misuse, as described by
+// MisuseError.
+type Unsemaphore interface {
+	// Remaining returns the number of resources that have not been released.
+	Remaining() int
+	// Release releases the specified number of resources. Releasing a
+	// negative number of resources, or more resources than remain, is misuse,
+	// and releases nothing.
 	Release(n int)
+	// ReleaseAll releases the remaining resources.
+	ReleaseAll()
 }
 
 type semaphore struct {
+	classed
 	size int
 
-	count AtomicInt
-	event Event
+	// mu guards the fields below
+	mu mutex
+	available int
+	// waiters are the acquisitions waiting for resources to be released, in
+	// the order they arrived
+	waiters []*semWaiter
+}
+
+// semWaiter is an acquisition of n resources that is waiting. ready is
+// buffered, and is signaled once the resources have been handed to it.
+type semWaiter struct {
+	ready SyncChannel
+	n int
+}
+
+type unsemaphore struct {
+	token
+	sem *semaphore
+	hold *holdRecord
+
+	// mu guards remaining
+	mu mutex
+	remaining int
 }
 
 // NewSemaphore returns a new Semaphore with the specified number of total/max
-// resources and the specified number of starting resources.
+// resources and the specified number of starting resources. The other size -
+// start resources are reserved: they are held by nobody, so they never become
+// available. Use NewSemaphoreWithReserve to be able to release them.
+// NewSemaphore panics if size is not positive or start is not between 0 and
+// size.
 func NewSemaphore(size, start int) Semaphore {
-	return &semaphore {
+	s, _ := NewSemaphoreWithReserve(size, start)
+	return s
+}
+
+// NewSemaphoreWithReserve is NewSemaphore, and also returns an Unsemaphore
+// that holds the size - start reserved resources, so that they can be made
+// available later.
+func NewSemaphoreWithReserve(size, start int) (Semaphore, Unsemaphore) {
+	if size < 1 {
+		panic("Invalid semaphore total resource count (negative or zero)")
+	}
+
+	if start < 0 {
+		panic("Invalid semaphore starting resource count (negative)")
+	}
+
+	if start > size {
+		panic("Invalid semaphore starting resource count (more than total count)")
+	}
+
+	s := &semaphore {
 		size: size,
 
-		count: NewAtomicInt(start),
-		event: NewEvent(),
+		mu: newMutex(),
+		available: start,
 	}
+	return s, s.newUnsemaphore(size - start, nil)
+}
+
+func (s *semaphore) Count() int {
+	return s.size
 }
 
-func (s *semaphore) Acquire(n int) {
-	for {
-		v := s.count.Read()
-		if n > v {
-			s.event.TrySubscribe(time.Microsecond)
-		} else if s.count.Write(v, v - n) {
-			return
+func (s *semaphore) Available() int {
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	return s.available
+}
+
+// check panics if n resources can never be acquired.
+func (s *semaphore) check(n int) {
+	if n < 0 {
+		panic("Tried to acquire a negative number of resources")
+	}
+	if n > s.size {
+		panic("Tried to acquire more resources than the semaphore is capable of having")
+	}
+}
+
+func (s *semaphore) Acquire(n int) Unsemaphore {
+	u, _ := s.AcquireContext(context.Background(), n)
+	return u
+}
+
+func (s *semaphore) AcquireContext(ctx context.Context, n int) (Unsemaphore, error) {
+	s.check(n)
+
+	w := beginWait(s, "Semaphore")
+	var err error
+	if !s.tryAcquire(n) {
+		w.block()
+		err = s.acquire(ctx, n)
+	}
+	h := w.end(err == nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.newUnsemaphore(n, h), nil
+}
+
+// acquire waits until n resources are handed to this acquisition or ctx is
+// done. A waiter is queued under s.mu in the same critical section that found
+// it could not proceed, and resources are handed out under s.mu, so a wakeup
+// cannot be missed.
+func (s *semaphore) acquire(ctx context.Context, n int) error {
+	s.mu.lock()
+	if len(s.waiters) == 0 && n <= s.available {
+		s.available -= n
+		s.mu.unlock()
+		return nil
+	}
+	w := &semWaiter{ready: NewSyncChannelN(1), n: n}
+	s.waiters = append(s.waiters, w)
+	s.mu.unlock()
+
+	select {
+	case <- w.ready:
+		return nil
+	case <- ctx.Done():
+	}
+
+	s.mu.lock()
+	for i, x := range s.waiters {
+		if x == w {
+			s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+			// the waiters behind this one may now be able to proceed
+			s.grant()
+			s.mu.unlock()
+			return ctx.Err()
 		}
 	}
+	s.mu.unlock()
+
+	// the resources were handed over while giving up, so give them back
+	s.release(n)
+	return ctx.Err()
 }
 
-func (s *semaphore) TryAcquire(n int) bool {
-	v := s.count.Read()
+func (s *semaphore) TryAcquire(n int) (Unsemaphore, bool) {
+	s.check(n)
 
-	if !s.count.Write(v, v - n) {
-		return false
+	if !s.tryAcquire(n) {
+		return nil, false
 	}
+	return s.newUnsemaphore(n, beginHold(s, "Semaphore")), true
+}
 
+func (s *semaphore) tryAcquire(n int) bool {
+	s.mu.lock()
+	defer s.mu.unlock()
+
+	if len(s.waiters) > 0 || n > s.available {
+		return false
+	}
+	s.available -= n
 	return true
 }
 
-func (s *semaphore) Release(n int) {
-	for {
-		var next int
+func (s *semaphore) TryAcquireErr(n int) (Unsemaphore, error) {
+	if u, ok := s.TryAcquire(n); ok {
+		return u, nil
+	}
+	return nil, ErrWouldBlock
+}
 
-		// get the current count
-		v := s.count.Read()
+func (s *semaphore) AcquireOne() Unsemaphore {
+	return s.Acquire(1)
+}
 
-		if n < 0 || n + v > s.size {
-			next = s.size
-		} else {
-			next = n + v
-		}
+func (s *semaphore) TryAcquireOne() (Unsemaphore, bool) {
+	return s.TryAcquire(1)
+}
+
+// release returns n resources to the semaphore, and hands them out to the
+// waiters that can now proceed.
+func (s *semaphore) release(n int) {
+	s.mu.lock()
+	defer s.mu.unlock()
 
-		// 
-		if !s.count.Write(v, next) {
-			continue
+	s.available += n
+	s.grant()
+}
+
+// grant hands resources to waiters in the order they arrived, stopping at the
+// first waiter that needs more than are available. s.mu must be held.
+func (s *semaphore) grant() {
+	for len(s.waiters) > 0 && s.waiters[0].n <= s.available {
+		w := s.waiters[0]
+		s.available -= w.n
+		// ready is buffered and signaled once, so this never blocks
+		w.ready.Send()
+		s.waiters[0] = nil
+		s.waiters = s.waiters[1:]
+	}
+}
+
+func (s *semaphore) newUnsemaphore(n int, h *holdRecord) *unsemaphore {
+	return &unsemaphore{
+		sem: s,
+		hold: h,
+		mu: newMutex(),
+		remaining: n,
+	}
+}
+
+func (u *unsemaphore) Remaining() int {
+	u.mu.lock()
+	defer u.mu.unlock()
+
+	return u.remaining
+}
+
+func (u *unsemaphore) Release(n int) {
+	u.release(n, useRelease)
+}
+
+func (u *unsemaphore) ReleaseAll() {
+	u.release(-1, useReleaseAll)
+}
+
+// release releases n of the remaining resources, or all of them if use is
+// useReleaseAll. The call that releases the last resource consumes the token
+// as use in the same critical section, so any later call is misuse.
+func (u *unsemaphore) release(n int, use *tokenUse) {
+	u.mu.lock()
+	r := u.remaining
+	if r == 0 {
+		u.mu.unlock()
+
+		// nothing remains, so this is misuse, unless the acquisition was of
+		// no resources and this is its first release
+		if u.consume("Unsemaphore", use) == nil {
+			u.hold.end()
 		}
+		return
+	}
 
-		s.event.PublishAll()
+	if use == useReleaseAll {
+		n = r
+	}
+	if n < 0 || n > r {
+		u.mu.unlock()
+		reportMisuse(&MisuseError{
+			Op: "Unsemaphore." + use.op,
+			Reason: fmt.Sprintf("released %d of %d remaining resources", n, r),
+		})
+		return
 	}
-}
\ No newline at end of file
+
+	if n == 0 {
+		u.mu.unlock()
+		return
+	}
+
+	u.remaining -= n
+	if u.remaining == 0 {
+		// the token cannot have been consumed while resources remained
+		u.consume("Unsemaphore", use)
+	}
+	u.mu.unlock()
+
+	if n == r {
+		// the acquisition is held until its last resource is released
+		u.hold.end()
+	}
+	u.sem.release(n)
+}
@@ -0,0 +1,296 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSemaphorePartialRelease(t *testing.T) {
+	s := NewSemaphore(5, 5)
+
+	u := s.Acquire(3)
+	if got := u.Remaining(); got != 3 {
+		t.Fatalf("Remaining() = %d, want 3", got)
+	}
+	if got := s.Available(); got != 2 {
+		t.Fatalf("Available() = %d, want 2", got)
+	}
+
+	u.Release(1)
+	if got := u.Remaining(); got != 2 {
+		t.Errorf("Remaining() = %d after Release(1), want 2", got)
+	}
+	if got := s.Available(); got != 3 {
+		t.Errorf("Available() = %d after Release(1), want 3", got)
+	}
+
+	u.Release(2)
+	if got := u.Remaining(); got != 0 {
+		t.Errorf("Remaining() = %d after Release(2), want 0", got)
+	}
+	if got := s.Available(); got != 5 {
+		t.Errorf("Available() = %d after Release(2), want 5", got)
+	}
+}
+
+func TestSemaphoreReleaseAll(t *testing.T) {
+	s := NewSemaphore(4, 4)
+
+	u := s.Acquire(4)
+	u.Release(1)
+	u.ReleaseAll()
+	if got := u.Remaining(); got != 0 {
+		t.Errorf("Remaining() = %d after ReleaseAll, want 0", got)
+	}
+	if got := s.Available(); got != 4 {
+		t.Errorf("Available() = %d after ReleaseAll, want 4", got)
+	}
+}
+
+func TestSemaphoreDoubleRelease(t *testing.T) {
+	caught := catchMisuse(t)
+	s := NewSemaphore(2, 2)
+
+	u := s.Acquire(2)
+	u.ReleaseAll()
+	u.ReleaseAll()
+	u.Release(1)
+
+	if got := s.Available(); got != 2 {
+		t.Errorf("Available() = %d, want 2", got)
+	}
+	errs := caught()
+	if len(errs) != 2 {
+		t.Fatalf("got %d misuse reports, want 2", len(errs))
+	}
+	if errs[0].Op != "Unsemaphore.ReleaseAll" || errs[0].Prior != "ReleaseAll" {
+		t.Errorf("got %q after %q, want Unsemaphore.ReleaseAll after ReleaseAll", errs[0].Op, errs[0].Prior)
+	}
+	if errs[1].Op != "Unsemaphore.Release" || errs[1].Prior != "ReleaseAll" {
+		t.Errorf("got %q after %q, want Unsemaphore.Release after ReleaseAll", errs[1].Op, errs[1].Prior)
+	}
+}
+
+func TestSemaphoreOverRelease(t *testing.T) {
+	caught := catchMisuse(t)
+	s := NewSemaphore(3, 3)
+
+	u := s.Acquire(2)
+	u.Release(3)
+	u.Release(-1)
+
+	// the misused calls release nothing
+	if got := u.Remaining(); got != 2 {
+		t.Errorf("Remaining() = %d, want 2", got)
+	}
+	if got := s.Available(); got != 1 {
+		t.Errorf("Available() = %d, want 1", got)
+	}
+
+	errs := caught()
+	if len(errs) != 2 {
+		t.Fatalf("got %d misuse reports, want 2", len(errs))
+	}
+	for _, err := range errs {
+		if err.Op != "Unsemaphore.Release" || err.Reason == "" {
+			t.Errorf("got %v, want an Unsemaphore.Release misuse with a reason", err)
+		}
+	}
+
+	// the token is still live
+	u.ReleaseAll()
+	if got := s.Available(); got != 3 {
+		t.Errorf("Available() = %d after ReleaseAll, want 3", got)
+	}
+	if len(caught()) != 2 {
+		t.Errorf("ReleaseAll after over-release was reported as misuse")
+	}
+}
+
+func TestSemaphoreConcurrentRelease(t *testing.T) {
+	caught := catchMisuse(t)
+	s := NewSemaphore(1, 1)
+
+	// racing the releases of the last resource must consume the token
+	// exactly once, and return the resource exactly once
+	for i := 0; i < 100; i++ {
+		u := s.Acquire(1)
+		done := make(chan struct{})
+		go func() {
+			u.Release(1)
+			close(done)
+		}()
+		u.ReleaseAll()
+		<- done
+	}
+
+	if got := s.Available(); got != 1 {
+		t.Errorf("Available() = %d, want 1", got)
+	}
+	if got := len(caught()); got != 100 {
+		t.Errorf("got %d misuse reports, want 100", got)
+	}
+}
+
+func TestSemaphoreReserve(t *testing.T) {
+	s, reserve := NewSemaphoreWithReserve(3, 1)
+	if got := s.Available(); got != 1 {
+		t.Fatalf("Available() = %d, want 1", got)
+	}
+	if got := reserve.Remaining(); got != 2 {
+		t.Fatalf("reserve Remaining() = %d, want 2", got)
+	}
+	if _, ok := s.TryAcquire(2); ok {
+		t.Fatal("TryAcquire(2) acquired reserved resources")
+	}
+
+	reserve.ReleaseAll()
+	u, ok := s.TryAcquire(3)
+	if !ok {
+		t.Fatal("TryAcquire(3) failed after the reserve was released")
+	}
+	u.ReleaseAll()
+}
+
+func TestSemaphoreWakesWaiter(t *testing.T) {
+	s := NewSemaphore(2, 2)
+	held := s.Acquire(2)
+
+	got := make(chan Unsemaphore)
+	go func() { got <- s.Acquire(2) }()
+
+	held.Release(1)
+	select {
+	case <- got:
+		t.Fatal("Acquire(2) returned with one resource available")
+	case <- time.After(10 * time.Millisecond):
+	}
+
+	held.Release(1)
+	select {
+	case u := <- got:
+		u.ReleaseAll()
+	case <- time.After(5 * time.Second):
+		t.Fatal("Acquire(2) was not woken by the release")
+	}
+}
+
+func TestSemaphoreAcquireContext(t *testing.T) {
+	s := NewSemaphore(1, 1)
+	held := s.Acquire(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := s.AcquireContext(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// giving up must not leave a waiter behind or take a resource
+	held.ReleaseAll()
+	if got := s.Available(); got != 1 {
+		t.Errorf("Available() = %d, want 1", got)
+	}
+}
+
+func TestSemaphoreTryAcquireTooMany(t *testing.T) {
+	s := NewSemaphore(3, 3)
+	held := s.Acquire(2)
+
+	// TryAcquire must not take more resources than are available, driving the
+	// count negative
+	if _, ok := s.TryAcquire(2); ok {
+		t.Fatal("TryAcquire(2) succeeded with one resource available")
+	}
+	if _, err := s.TryAcquireErr(2); !errors.Is(err, ErrWouldBlock) {
+		t.Fatalf("TryAcquireErr(2) = %v, want %v", err, ErrWouldBlock)
+	}
+	if got := s.Available(); got != 1 {
+		t.Fatalf("Available() = %d, want 1", got)
+	}
+
+	u, ok := s.TryAcquire(1)
+	if !ok {
+		t.Fatal("TryAcquire(1) failed with one resource available")
+	}
+	u.ReleaseAll()
+	held.ReleaseAll()
+}
+
+// semWaiting returns the number of acquisitions waiting on s.
+func semWaiting(s Semaphore) int {
+	sem := s.(*semaphore)
+	sem.mu.lock()
+	defer sem.mu.unlock()
+	return len(sem.waiters)
+}
+
+func TestSemaphoreLargeAcquireNotStarved(t *testing.T) {
+	s := NewSemaphore(2, 2)
+	held := s.Acquire(1)
+
+	got := make(chan Unsemaphore)
+	go func() { got <- s.Acquire(2) }()
+	waitUntil(t, "Acquire(2) waits", func() bool { return semWaiting(s) == 1 })
+
+	// a resource is available, but it belongs to the waiter once enough are
+	// released
+	if _, ok := s.TryAcquire(1); ok {
+		t.Fatal("TryAcquire(1) went ahead of a waiting Acquire(2)")
+	}
+	small := make(chan Unsemaphore)
+	go func() { small <- s.Acquire(1) }()
+	waitUntil(t, "Acquire(1) waits", func() bool { return semWaiting(s) == 2 })
+
+	held.ReleaseAll()
+	select {
+	case u := <- got:
+		u.ReleaseAll()
+	case <- small:
+		t.Fatal("Acquire(1) went ahead of a waiting Acquire(2)")
+	case <- time.After(5 * time.Second):
+		t.Fatal("Acquire(2) was not served once the resources were released")
+	}
+	select {
+	case u := <- small:
+		u.ReleaseAll()
+	case <- time.After(5 * time.Second):
+		t.Fatal("Acquire(1) was not served after Acquire(2)")
+	}
+}
+
+func TestSemaphoreCanceledWaiterUnblocksQueue(t *testing.T) {
+	s := NewSemaphore(2, 2)
+	held := s.Acquire(2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan error)
+	go func() {
+		_, err := s.AcquireContext(ctx, 2)
+		failed <- err
+	}()
+	waitUntil(t, "Acquire(2) waits", func() bool { return semWaiting(s) == 1 })
+	got := make(chan Unsemaphore)
+	go func() { got <- s.Acquire(1) }()
+	waitUntil(t, "Acquire(1) waits", func() bool { return semWaiting(s) == 2 })
+
+	// one resource is enough for the second waiter, which is held back only
+	// by the first until it gives up
+	held.Release(1)
+	cancel()
+	if err := <- failed; !errors.Is(err, context.Canceled) {
+		t.Fatalf("AcquireContext() = %v, want %v", err, context.Canceled)
+	}
+	select {
+	case u := <- got:
+		u.ReleaseAll()
+	case <- time.After(5 * time.Second):
+		t.Fatal("Acquire(1) was not served once the waiter ahead of it gave up")
+	}
+
+	held.ReleaseAll()
+	if got := s.Available(); got != 2 {
+		t.Errorf("Available() = %d, want 2", got)
+	}
+}
@@ -0,0 +1,251 @@
+package chansync
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// SeqLock is a sequence lock, for small read-mostly data that readers copy out.
+// Writers exclude each other with a Lock, but readers never block writers.
+// Instead, a reader reads the data optimistically and checks afterwards
+// whether a write overlapped the read, in which case it discards what it read
+// and tries again:
+//
+//	for {
+//		seq := l.ReadBegin()
+//		x, y := data.x, data.y
+//		if !l.ReadRetry(seq) {
+//			return x, y
+//		}
+//	}
+//
+// A reader must not act on what it read until ReadRetry returns false, since
+// the data may have been torn by a write. A steady stream of writers can
+// starve readers.
+//
+// Reads that overlap a write are data races as far as the Go memory model and
+// the race detector are concerned, so SeqLock is only suitable for data that
+// can be copied out and discarded if torn. In particular, a reader must not
+// follow a pointer it read before ReadRetry returns false.
+type SeqLock interface {
+	// Acquire blocks until the write lock can be acquired. Acquire returns a
+	// reference that can be used to release the lock, which ends the write.
+	Acquire() Unlock
+	// AcquireContext blocks until the write lock can be acquired or ctx is
+	// done. If ctx is done first, AcquireContext returns (nil, ctx.Err()).
+	// Otherwise AcquireContext returns (u, nil) where u is a reference that
+	// can be used to release the lock.
+	AcquireContext(ctx context.Context) (Unlock, error)
+	// TryAcquire attempts to acquire the write lock. If the lock cannot be
+	// acquired, TryAcquire returns (nil, false). Otherwise TryAcquire returns
+	// (u, true) where u is a reference that can be used to release the lock.
+	TryAcquire() (Unlock, bool)
+
+	// ReadBegin begins a read, and returns the sequence number to pass to
+	// ReadRetry. If a write is in progress, ReadBegin yields until it ends.
+	ReadBegin() uint64
+	// ReadRetry returns whether or not a write began after the ReadBegin call
+	// that returned seq, in which case the read must be discarded and
+	// retried.
+	ReadRetry(seq uint64) bool
+}
+
+type seqLock struct {
+	lock Lock
+
+	// seq is odd while a write is in progress
+	seq atomic.Uint64
+}
+
+type seqUnlock struct {
+	token
+	lock *seqLock
+	unlock Unlock
+}
+
+// NewSeqLock returns a new sequence lock.
+func NewSeqLock() SeqLock {
+	return newSeqLock()
+}
+
+func newSeqLock() *seqLock {
+	return &seqLock{lock: NewLock()}
+}
+
+func (l *seqLock) Acquire() Unlock {
+	return l.begin(l.lock.Acquire())
+}
+
+func (l *seqLock) AcquireContext(ctx context.Context) (Unlock, error) {
+	u, err := l.lock.AcquireContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.begin(u), nil
+}
+
+func (l *seqLock) TryAcquire() (Unlock, bool) {
+	u, ok := l.lock.TryAcquire()
+	if !ok {
+		return nil, false
+	}
+	return l.begin(u), true
+}
+
+// begin begins a write, once the write lock is held as u.
+func (l *seqLock) begin(u Unlock) Unlock {
+	l.seq.Add(1)
+	return &seqUnlock{
+		lock: l,
+		unlock: u,
+	}
+}
+
+func (l *seqLock) ReadBegin() uint64 {
+	for {
+		seq := l.seq.Load()
+		if seq & 1 == 0 {
+			return seq
+		}
+		runtime.Gosched()
+	}
+}
+
+func (l *seqLock) ReadRetry(seq uint64) bool {
+	return l.seq.Load() != seq
+}
+
+func (u *seqUnlock) Release() {
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+
+	// end the write
+	u.lock.seq.Add(1)
+	u.unlock.Release()
+}
+
+// SeqValue is a value protected by a SeqLock. Loads retry automatically, and
+// never block stores. T should be small, since a load copies it, possibly
+// more than once.
+//
+// A load that overlaps a store may copy a torn value before it retries, so T
+// must not contain pointers, strings, slices, maps, channels, functions or
+// interfaces, which would be unsafe to copy torn; NewSeqValue panics if it
+// does. The value is copied through atomic machine words, so unlike the reads
+// described by SeqLock, loads that overlap a store are not data races.
+type SeqValue[T any] interface {
+	// Load returns the value.
+	Load() T
+	// Store sets the value, blocking while another store or update is in
+	// progress.
+	Store(v T)
+	// Update sets the value to f applied to the current value, excluding
+	// other stores and updates until f returns.
+	Update(f func(T) T)
+}
+
+type seqValue[T any] struct {
+	lock *seqLock
+	words seqWords
+}
+
+// NewSeqValue returns a new SeqValue holding v. NewSeqValue panics if T
+// contains pointers, as described by SeqValue.
+func NewSeqValue[T any](v T) SeqValue[T] {
+	if t := reflect.TypeFor[T](); hasPointers(t) {
+		panic("chansync: SeqValue of " + t.String() + ", which contains pointers")
+	}
+
+	x := &seqValue[T]{
+		lock: newSeqLock(),
+		words: newSeqWords(unsafe.Sizeof(v)),
+	}
+	x.words.store(unsafe.Pointer(&v), unsafe.Sizeof(v))
+	return x
+}
+
+func (x *seqValue[T]) Load() T {
+	var v T
+	for {
+		seq := x.lock.ReadBegin()
+		x.words.load(unsafe.Pointer(&v), unsafe.Sizeof(v))
+		if !x.lock.ReadRetry(seq) {
+			return v
+		}
+	}
+}
+
+func (x *seqValue[T]) Store(v T) {
+	u := x.lock.Acquire()
+	defer u.Release()
+
+	x.words.store(unsafe.Pointer(&v), unsafe.Sizeof(v))
+}
+
+func (x *seqValue[T]) Update(f func(T) T) {
+	u := x.lock.Acquire()
+	defer u.Release()
+
+	// the writer is the only goroutine that can change the value, so it can
+	// read it without retrying
+	var v T
+	x.words.load(unsafe.Pointer(&v), unsafe.Sizeof(v))
+	v = f(v)
+	x.words.store(unsafe.Pointer(&v), unsafe.Sizeof(v))
+}
+
+// hasPointers returns whether or not values of t contain pointers, including
+// the pointers within strings, slices, maps, channels, functions and
+// interfaces.
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	case reflect.Pointer, reflect.UnsafePointer, reflect.String, reflect.Slice, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface:
+		return true
+	default:
+		return false
+	}
+}
+
+const wordSize = unsafe.Sizeof(uintptr(0))
+
+// seqWords holds a value without pointers as machine words, so that it can be
+// copied while it is being written without a data race.
+type seqWords []atomic.Uintptr
+
+func newSeqWords(size uintptr) seqWords {
+	return make(seqWords, (size + wordSize - 1) / wordSize)
+}
+
+// load copies the words to the size bytes at p.
+func (w seqWords) load(p unsafe.Pointer, size uintptr) {
+	for i := range w {
+		off := uintptr(i) * wordSize
+		n := min(wordSize, size - off)
+		x := w[i].Load()
+		copy(unsafe.Slice((*byte)(unsafe.Add(p, off)), n), unsafe.Slice((*byte)(unsafe.Pointer(&x)), n))
+	}
+}
+
+// store copies the size bytes at p to the words.
+func (w seqWords) store(p unsafe.Pointer, size uintptr) {
+	for i := range w {
+		off := uintptr(i) * wordSize
+		n := min(wordSize, size - off)
+		var x uintptr
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(&x)), n), unsafe.Slice((*byte)(unsafe.Add(p, off)), n))
+		w[i].Store(x)
+	}
+}
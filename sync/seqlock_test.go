@@ -0,0 +1,138 @@
+package chansync
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSeqLockReadRetry(t *testing.T) {
+	l := NewSeqLock()
+
+	seq := l.ReadBegin()
+	if l.ReadRetry(seq) {
+		t.Fatal("ReadRetry with no write")
+	}
+
+	u := l.Acquire()
+	if !l.ReadRetry(seq) {
+		t.Fatal("no ReadRetry while a write is in progress")
+	}
+	u.Release()
+	if !l.ReadRetry(seq) {
+		t.Fatal("no ReadRetry after a write")
+	}
+
+	seq = l.ReadBegin()
+	if l.ReadRetry(seq) {
+		t.Fatal("ReadRetry for a read that began after the write")
+	}
+}
+
+func TestSeqLockReadBeginWaitsForWrite(t *testing.T) {
+	l := NewSeqLock()
+	u := l.Acquire()
+
+	began := make(chan uint64)
+	go func() { began <- l.ReadBegin() }()
+	select {
+	case <- began:
+		t.Fatal("ReadBegin returned while a write is in progress")
+	case <- time.After(10 * time.Millisecond):
+	}
+
+	u.Release()
+	select {
+	case seq := <- began:
+		if l.ReadRetry(seq) {
+			t.Error("ReadRetry for a read that began after the write")
+		}
+	case <- time.After(5 * time.Second):
+		t.Fatal("ReadBegin did not return once the write ended")
+	}
+}
+
+func TestSeqValue(t *testing.T) {
+	v := NewSeqValue(1)
+	v.Store(2)
+	v.Update(func(x int) int { return x * 10 })
+	if got := v.Load(); got != 20 {
+		t.Errorf("Load() = %d, want 20", got)
+	}
+}
+
+func TestSeqValueOddSize(t *testing.T) {
+	// the value does not fill its last word
+	type odd struct {
+		a [5]byte
+		b int16
+	}
+	v := NewSeqValue(odd{a: [5]byte{1, 2, 3, 4, 5}, b: -7})
+	v.Update(func(x odd) odd {
+		x.a[4]++
+		return x
+	})
+	if got, want := v.Load(), (odd{a: [5]byte{1, 2, 3, 4, 6}, b: -7}); got != want {
+		t.Errorf("Load() = %v, want %v", got, want)
+	}
+}
+
+func TestSeqValueRejectsPointers(t *testing.T) {
+	for name, f := range map[string]func(){
+		"pointer": func() { NewSeqValue(new(int)) },
+		"string": func() { NewSeqValue("") },
+		"slice": func() { NewSeqValue([]int(nil)) },
+		"interface": func() { NewSeqValue[any](nil) },
+		"field": func() { NewSeqValue(struct{ n int; m map[int]int }{}) },
+		"element": func() { NewSeqValue([2]func(){}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("NewSeqValue did not panic")
+				}
+			}()
+			f()
+		})
+	}
+}
+
+func TestSeqValueNeverTorn(t *testing.T) {
+	// a value that is torn by a store has fields from different stores
+	type wide [64]int64
+	v := NewSeqValue(wide{})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := int64(1); ; i++ {
+			select {
+			case <- stop:
+				return
+			default:
+			}
+			var x wide
+			for j := range x {
+				x[j] = i
+			}
+			v.Store(x)
+		}
+	}()
+
+	// the race detector slows loads down, so stop after a second
+	deadline := time.Now().Add(time.Second)
+	for n := 0; n < 1000000 && (n % 1024 != 0 || time.Now().Before(deadline)); n++ {
+		x := v.Load()
+		for _, f := range x {
+			if f != x[0] {
+				close(stop)
+				wg.Wait()
+				t.Fatalf("Load() returned a torn value: %v", x)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
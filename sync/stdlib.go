@@ -0,0 +1,495 @@
+package chansync
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RWLocker is the method set of sync.RWMutex.
+type RWLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+	RLocker() sync.Locker
+}
+
+type locker struct {
+	lock Lock
+	held DataChannel[Unlock]
+}
+
+type rwlocker struct {
+	lock ReadWriteLock
+	held DataChannel[WriteUnlock]
+
+	// mu guards reads
+	mu Lock
+	reads []ReadUnlock
+}
+
+type rlocker rwlocker
+
+// NewLocker returns a sync.Locker backed by l. Like sync.Mutex, the locker may
+// be unlocked by a different goroutine than the one that locked it. Unlocking
+// a locker that is not locked panics.
+func NewLocker(l Lock) sync.Locker {
+	return &locker{
+		lock: l,
+		held: NewDataChannelN[Unlock](1),
+	}
+}
+
+func (l *locker) Lock() {
+	l.held.Send(l.lock.Acquire())
+}
+
+func (l *locker) Unlock() {
+	u, r := l.held.TryRecv()
+	if r != ChannelOpSuccess {
+		panic("chansync: unlock of unlocked Locker")
+	}
+	u.Release()
+}
+
+// NewRWLocker returns an RWLocker backed by l. Like sync.RWMutex, read locks
+// are not tied to goroutines, so RUnlock releases any one of the outstanding
+// read locks. Unlocking a locker that is not locked panics, as does RUnlock if
+// no read lock is held.
+func NewRWLocker(l ReadWriteLock) RWLocker {
+	return &rwlocker{
+		lock: l,
+		held: NewDataChannelN[WriteUnlock](1),
+		mu: newInternalLock(),
+	}
+}
+
+func (l *rwlocker) Lock() {
+	l.held.Send(l.lock.AcquireWrite())
+}
+
+func (l *rwlocker) Unlock() {
+	u, r := l.held.TryRecv()
+	if r != ChannelOpSuccess {
+		panic("chansync: unlock of unlocked RWLocker")
+	}
+	u.Release()
+}
+
+func (l *rwlocker) RLock() {
+	u := l.lock.AcquireRead()
+
+	m := l.mu.Acquire()
+	defer m.Release()
+	l.reads = append(l.reads, u)
+}
+
+func (l *rwlocker) RUnlock() {
+	m := l.mu.Acquire()
+	n := len(l.reads)
+	if n == 0 {
+		m.Release()
+		panic("chansync: RUnlock of unlocked RWLocker")
+	}
+	u := l.reads[n-1]
+	l.reads[n-1] = nil
+	l.reads = l.reads[:n-1]
+	m.Release()
+
+	u.Release()
+}
+
+// RLocker returns a sync.Locker that implements Lock and Unlock by calling
+// RLock and RUnlock.
+func (l *rwlocker) RLocker() sync.Locker {
+	return (*rlocker)(l)
+}
+
+func (r *rlocker) Lock() {
+	(*rwlocker)(r).RLock()
+}
+
+func (r *rlocker) Unlock() {
+	(*rwlocker)(r).RUnlock()
+}
+
+type mutexLock struct {
+	m *sync.Mutex
+}
+
+type mutexUnlock struct {
+	token
+	m *sync.Mutex
+}
+
+type rwmutexLock struct {
+	m *sync.RWMutex
+
+	// up is held with the upgradable read lock
+	up sync.Mutex
+
+	// mu guards stats
+	mu sync.Mutex
+	stats ReadWriteLockStats
+}
+
+type rwmutexReadUnlock struct {
+	token
+	l *rwmutexLock
+}
+
+type rwmutexWriteUnlock struct {
+	token
+	l *rwmutexLock
+}
+
+type rwmutexUpgradableUnlock struct {
+	token
+	l *rwmutexLock
+}
+
+// FromMutex returns a Lock backed by m. sync.Mutex cannot be abandoned while
+// locking, so when an AcquireContext, AcquireTimeout or AcquireUntil call
+// gives up, a goroutine is left blocked in m.Lock. That goroutine exits as
+// soon as it obtains m, unlocking m again, so it lives until m is next
+// unlocked by its holder.
+func FromMutex(m *sync.Mutex) Lock {
+	return &mutexLock{m: m}
+}
+
+// acquireMutex locks a stdlib mutex, giving up if cancel fires first. If the
+// mutex is not immediately available, the lock call is made from a helper
+// goroutine. If the caller gives up, the helper cannot be stopped, and remains
+// blocked in lock until it obtains the mutex, which it then unlocks before
+// exiting, so that giving up never leaves the mutex held.
+func acquireMutex(tryLock func() bool, lock, unlock func(), cancel <-chan struct{}) bool {
+	if tryLock() {
+		return true
+	}
+
+	got := NewSyncChannel()
+	abandon := NewSyncChannel()
+	go func() {
+		lock()
+		select {
+		case got <- empty:
+		case <- abandon:
+			unlock()
+		}
+	}()
+
+	select {
+	case <- got:
+		return true
+	case <- cancel:
+		abandon.Close()
+		return false
+	}
+}
+
+func (l *mutexLock) acquire(cancel <-chan struct{}) bool {
+	return acquireMutex(l.m.TryLock, l.m.Lock, l.m.Unlock, cancel)
+}
+
+func (l *mutexLock) newUnlock() Unlock {
+	return &mutexUnlock{m: l.m}
+}
+
+func (l *mutexLock) Acquire() Unlock {
+	l.m.Lock()
+	return l.newUnlock()
+}
+
+func (l *mutexLock) AcquireContext(ctx context.Context) (Unlock, error) {
+	if !l.acquire(ctx.Done()) {
+		return nil, ctx.Err()
+	}
+	return l.newUnlock(), nil
+}
+
+func (l *mutexLock) TryAcquire() (Unlock, bool) {
+	if l.m.TryLock() {
+		return l.newUnlock(), true
+	}
+	return nil, false
+}
+
+func (l *mutexLock) TryAcquireErr() (Unlock, error) {
+	if u, ok := l.TryAcquire(); ok {
+		return u, nil
+	}
+	return nil, ErrWouldBlock
+}
+
+func (l *mutexLock) AcquireTimeout(timeout time.Duration) (Unlock, ChannelOpResult) {
+	t := NewTimer(timeout)
+	defer t.Stop()
+
+	if !l.acquire(t.C()) {
+		return nil, ChannelOpTimeout
+	}
+	return l.newUnlock(), ChannelOpSuccess
+}
+
+func (l *mutexLock) AcquireUntil(deadline time.Time) (Unlock, ChannelOpResult) {
+	return l.AcquireTimeout(time.Until(deadline))
+}
+
+func (u *mutexUnlock) Release() {
+	if u.consume("Unlock", useRelease) != nil {
+		return
+	}
+	u.m.Unlock()
+}
+
+// FromRWMutex returns a ReadWriteLock backed by m. As with FromMutex, a
+// canceled acquisition leaves a goroutine blocked on m until it obtains m and
+// releases it again.
+//
+// sync.RWMutex cannot change modes atomically, so WriteUnlock.Demote is not
+// atomic: it releases the write lock before acquiring a read lock, and another
+// goroutine may acquire m in between. Demote therefore blocks while a writer
+// that got in between holds m, unlike the Demote of a lock returned by
+// NewReadWriteLock, which always returns immediately.
+//
+// A promotion or an upgrade must not let another writer in between, so read
+// locks cannot be promoted and upgradable read locks cannot be upgraded:
+// ReadUnlock.PromoteContext and UpgradableUnlock.UpgradeContext fail with
+// ErrUpgradeUnsupported, and Promote, TryPromote, Upgrade and TryUpgrade fail
+// likewise, leaving the read lock held. Upgradable read locks can still be
+// acquired, excluding each other, and downgraded. Stats only reflects
+// acquisitions made through the returned ReadWriteLock, not direct uses of m.
+func FromRWMutex(m *sync.RWMutex) ReadWriteLock {
+	return &rwmutexLock{m: m}
+}
+
+// acquire locks m in the mode of tryLock, lock and unlock, giving up if cancel
+// is closed first. A nil cancel is never closed. If m cannot be locked
+// immediately, the acquisition is counted in waiting, which is one of the
+// counters of l.stats, while it waits.
+func (l *rwmutexLock) acquire(tryLock func() bool, lock, unlock func(), cancel <-chan struct{}, waiting *int) bool {
+	if tryLock() {
+		return true
+	}
+
+	l.mu.Lock()
+	*waiting++
+	l.mu.Unlock()
+
+	start := time.Now()
+	ok := true
+	if cancel == nil {
+		lock()
+	} else {
+		ok = acquireMutex(tryLock, lock, unlock, cancel)
+	}
+
+	l.mu.Lock()
+	*waiting--
+	l.stats.WaitTime += time.Since(start)
+	l.mu.Unlock()
+	return ok
+}
+
+func (l *rwmutexLock) acquireRead(cancel <-chan struct{}) bool {
+	return l.acquire(l.m.TryRLock, l.m.RLock, l.m.RUnlock, cancel, &l.stats.WaitingReaders)
+}
+
+func (l *rwmutexLock) acquireWrite(cancel <-chan struct{}) bool {
+	return l.acquire(l.m.TryLock, l.m.Lock, l.m.Unlock, cancel, &l.stats.WaitingWriters)
+}
+
+// update records a change in the locks held through l. readers is the change
+// in the number of read locks, writer is whether or not the write lock is now
+// held, and acquired is whether or not the change is a new acquisition.
+func (l *rwmutexLock) update(readers int, writer, acquired bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.stats.Readers += readers
+	l.stats.Writer = writer
+	if acquired {
+		l.stats.Acquisitions++
+	}
+}
+
+func (l *rwmutexLock) newReadUnlock(acquired bool) ReadUnlock {
+	l.update(1, false, acquired)
+	return &rwmutexReadUnlock{l: l}
+}
+
+func (l *rwmutexLock) newWriteUnlock() WriteUnlock {
+	l.update(0, true, true)
+	return &rwmutexWriteUnlock{l: l}
+}
+
+func (l *rwmutexLock) AcquireRead() ReadUnlock {
+	l.acquireRead(nil)
+	return l.newReadUnlock(true)
+}
+
+func (l *rwmutexLock) AcquireReadContext(ctx context.Context) (ReadUnlock, error) {
+	if !l.acquireRead(ctx.Done()) {
+		return nil, ctx.Err()
+	}
+	return l.newReadUnlock(true), nil
+}
+
+func (l *rwmutexLock) TryAcquireRead() (ReadUnlock, bool) {
+	if l.m.TryRLock() {
+		return l.newReadUnlock(true), true
+	}
+	return nil, false
+}
+
+func (l *rwmutexLock) AcquireWrite() WriteUnlock {
+	l.acquireWrite(nil)
+	return l.newWriteUnlock()
+}
+
+func (l *rwmutexLock) AcquireWriteContext(ctx context.Context) (WriteUnlock, error) {
+	if !l.acquireWrite(ctx.Done()) {
+		return nil, ctx.Err()
+	}
+	return l.newWriteUnlock(), nil
+}
+
+func (l *rwmutexLock) TryAcquireWrite() (WriteUnlock, bool) {
+	if l.m.TryLock() {
+		return l.newWriteUnlock(), true
+	}
+	return nil, false
+}
+
+func (l *rwmutexLock) AcquireUpgradable() UpgradableUnlock {
+	u, _ := l.AcquireUpgradableContext(context.Background())
+	return u
+}
+
+func (l *rwmutexLock) AcquireUpgradableContext(ctx context.Context) (UpgradableUnlock, error) {
+	if !l.acquire(l.up.TryLock, l.up.Lock, l.up.Unlock, ctx.Done(), &l.stats.WaitingReaders) {
+		return nil, ctx.Err()
+	}
+	if !l.acquireRead(ctx.Done()) {
+		l.up.Unlock()
+		return nil, ctx.Err()
+	}
+	l.update(1, false, true)
+	return &rwmutexUpgradableUnlock{l: l}, nil
+}
+
+func (l *rwmutexLock) TryAcquireUpgradable() (UpgradableUnlock, bool) {
+	if !l.up.TryLock() {
+		return nil, false
+	}
+	if !l.m.TryRLock() {
+		l.up.Unlock()
+		return nil, false
+	}
+	l.update(1, false, true)
+	return &rwmutexUpgradableUnlock{l: l}, true
+}
+
+func (l *rwmutexLock) Stats() ReadWriteLockStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
+
+// releaseRead releases a read lock of m held through l.
+func (l *rwmutexLock) releaseRead() {
+	l.update(-1, false, false)
+	l.m.RUnlock()
+}
+
+func (r *rwmutexReadUnlock) Release() {
+	if r.consume("ReadUnlock", useRelease) != nil {
+		return
+	}
+	r.l.releaseRead()
+}
+
+func (r *rwmutexReadUnlock) Promote() WriteUnlock {
+	w, _ := r.PromoteContext(context.Background())
+	return w
+}
+
+func (r *rwmutexReadUnlock) PromoteContext(ctx context.Context) (WriteUnlock, error) {
+	if err := r.consume("ReadUnlock", usePromote); err != nil {
+		return nil, err
+	}
+
+	// sync.RWMutex would have to release the read lock first
+	r.restore()
+	return nil, ErrUpgradeUnsupported
+}
+
+func (r *rwmutexReadUnlock) TryPromote() (WriteUnlock, bool) {
+	if r.consume("ReadUnlock", useTryPromote) != nil {
+		return nil, false
+	}
+
+	r.restore()
+	return nil, false
+}
+
+func (u *rwmutexUpgradableUnlock) Release() {
+	if u.consume("UpgradableUnlock", useRelease) != nil {
+		return
+	}
+	u.l.releaseRead()
+	u.l.up.Unlock()
+}
+
+func (u *rwmutexUpgradableUnlock) Upgrade() WriteUnlock {
+	w, _ := u.UpgradeContext(context.Background())
+	return w
+}
+
+func (u *rwmutexUpgradableUnlock) UpgradeContext(ctx context.Context) (WriteUnlock, error) {
+	if err := u.consume("UpgradableUnlock", useUpgrade); err != nil {
+		return nil, err
+	}
+
+	// sync.RWMutex would have to release the read lock first
+	u.restore()
+	return nil, ErrUpgradeUnsupported
+}
+
+func (u *rwmutexUpgradableUnlock) TryUpgrade() (WriteUnlock, bool) {
+	if u.consume("UpgradableUnlock", useTryUpgrade) != nil {
+		return nil, false
+	}
+
+	u.restore()
+	return nil, false
+}
+
+func (u *rwmutexUpgradableUnlock) Downgrade() ReadUnlock {
+	if u.consume("UpgradableUnlock", useDowngrade) != nil {
+		return nil
+	}
+	u.l.up.Unlock()
+	return &rwmutexReadUnlock{l: u.l}
+}
+
+func (w *rwmutexWriteUnlock) Release() {
+	if w.consume("WriteUnlock", useRelease) != nil {
+		return
+	}
+	w.l.update(0, false, false)
+	w.l.m.Unlock()
+}
+
+func (w *rwmutexWriteUnlock) Demote() ReadUnlock {
+	if w.consume("WriteUnlock", useDemote) != nil {
+		return nil
+	}
+
+	l := w.l
+	l.update(0, false, false)
+	l.m.Unlock()
+	l.acquireRead(nil)
+	return l.newReadUnlock(false)
+}
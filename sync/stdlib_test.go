@@ -0,0 +1,91 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFromMutexAbandonedHelperUnlocks(t *testing.T) {
+	var m sync.Mutex
+	l := FromMutex(&m)
+
+	m.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if _, err := l.AcquireContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcquireContext() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the abandoned helper obtains m once it is unlocked, and must unlock it
+	// again
+	m.Unlock()
+	waitUntil(t, "the abandoned helper unlocks m", func() bool {
+		if !m.TryLock() {
+			return false
+		}
+		m.Unlock()
+		return true
+	})
+}
+
+func TestFromRWMutexUpgradeUnsupported(t *testing.T) {
+	var m sync.RWMutex
+	l := FromRWMutex(&m)
+
+	u := l.AcquireUpgradable()
+	if _, err := u.UpgradeContext(context.Background()); !errors.Is(err, ErrUpgradeUnsupported) {
+		t.Fatalf("UpgradeContext() = %v, want %v", err, ErrUpgradeUnsupported)
+	}
+	if _, ok := u.TryUpgrade(); ok {
+		t.Fatal("TryUpgrade succeeded")
+	}
+	// Upgrade documents that it returns nil, so callers can check for it
+	if w := u.Upgrade(); w != nil {
+		t.Fatal("Upgrade succeeded")
+	}
+
+	// the upgradable read lock is still held, and still excludes others
+	if _, ok := l.TryAcquireUpgradable(); ok {
+		t.Fatal("TryAcquireUpgradable succeeded while an upgradable read lock is held")
+	}
+	if m.TryLock() {
+		t.Fatal("m was write locked while an upgradable read lock is held")
+	}
+	u.Release()
+	if !m.TryLock() {
+		t.Fatal("m is still locked after the upgradable read lock was released")
+	}
+	m.Unlock()
+}
+
+func TestFromRWMutexPromoteUnsupported(t *testing.T) {
+	var m sync.RWMutex
+	l := FromRWMutex(&m)
+
+	r := l.AcquireRead()
+	if _, err := r.PromoteContext(context.Background()); !errors.Is(err, ErrUpgradeUnsupported) {
+		t.Fatalf("PromoteContext() = %v, want %v", err, ErrUpgradeUnsupported)
+	}
+	if _, ok := r.TryPromote(); ok {
+		t.Fatal("TryPromote succeeded")
+	}
+	if w := r.Promote(); w != nil {
+		t.Fatal("Promote succeeded")
+	}
+
+	// the read lock was never released, so no writer got in between
+	if m.TryLock() {
+		t.Fatal("m was write locked while a read lock is held")
+	}
+	if got := l.Stats().Readers; got != 1 {
+		t.Errorf("Stats().Readers = %d, want 1", got)
+	}
+	r.Release()
+	if !m.TryLock() {
+		t.Fatal("m is still locked after the read lock was released")
+	}
+	m.Unlock()
+}
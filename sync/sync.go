@@ -1,17 +1,29 @@
 package chansync
 
+import (
+	"context"
+)
+
 // Sync is a synchronization tool that can be used to synchronize execution of
 // two concurrent routines.
 type Sync interface {
 	// SyncLeft returns immediately if a call to SyncRight is blocked.
 	// Otherwise, SyncLeft blocks until SyncRight is called.
 	SyncLeft()
+	// SyncLeftContext behaves like SyncLeft, but gives up once ctx is done.
+	// If ctx is done before SyncRight is called, SyncLeftContext returns
+	// ctx.Err(). Otherwise SyncLeftContext returns nil.
+	SyncLeftContext(ctx context.Context) error
 	// TrySyncLeft returns true if a call to SyncRight is blocked. Otherwise
 	// TrySyncLeft returns false.
 	TrySyncLeft() bool
 	// SyncRight returns immediately if a call to SyncLeft is blocked.
 	// Otherwise, SyncRight blocks until SyncLeft is called.
 	SyncRight()
+	// SyncRightContext behaves like SyncRight, but gives up once ctx is done.
+	// If ctx is done before SyncLeft is called, SyncRightContext returns
+	// ctx.Err(). Otherwise SyncRightContext returns nil.
+	SyncRightContext(ctx context.Context) error
 	// TrySyncRight returns true if a call to SyncLeft is blocked. Otherwise
 	// TrySyncRight returns false.
 	TrySyncRight() bool
@@ -33,6 +45,10 @@ func (s *syncOnce) SyncLeft() {
 	s.ch.Send()
 }
 
+func (s *syncOnce) SyncLeftContext(ctx context.Context) error {
+	return s.ch.SendContext(ctx)
+}
+
 func (s *syncOnce) TrySyncLeft() bool {
 	return s.ch.TrySend() == ChannelOpSuccess
 }
@@ -41,6 +57,11 @@ func (s *syncOnce) SyncRight() {
 	s.ch.Recv()
 }
 
+func (s *syncOnce) SyncRightContext(ctx context.Context) error {
+	_, err := s.ch.RecvContext(ctx)
+	return err
+}
+
 func (s *syncOnce) TrySyncRight() bool {
 	return s.ch.TryRecv() == ChannelOpSuccess
 }
\ No newline at end of file
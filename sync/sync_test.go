@@ -0,0 +1,35 @@
+package chansync
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSyncContext(t *testing.T) {
+	s := NewSync()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	if err := s.SyncLeftContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SyncLeftContext() = %v with no SyncRight, want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.SyncRightContext(canceledContext()); !errors.Is(err, context.Canceled) {
+		t.Errorf("SyncRightContext() = %v when canceled, want %v", err, context.Canceled)
+	}
+
+	// the calls that gave up left nothing behind for the next pair to meet
+	if s.TrySyncRight() {
+		t.Fatal("TrySyncRight met a SyncLeftContext that gave up")
+	}
+
+	left := make(chan error)
+	go func() { left <- s.SyncLeftContext(context.Background()) }()
+	if err := s.SyncRightContext(context.Background()); err != nil {
+		t.Errorf("SyncRightContext() = %v, want nil", err)
+	}
+	if err := <- left; err != nil {
+		t.Errorf("SyncLeftContext() = %v, want nil", err)
+	}
+}
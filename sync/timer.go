@@ -0,0 +1,188 @@
+package chansync
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Timer is a cancelable timeout. Every Timer is driven by a single, shared
+// timer goroutine, so a Timer that is stopped before it fires leaves nothing
+// behind.
+type Timer interface {
+	// C returns the channel that is signaled when the timer fires. The
+	// channel is buffered, so the timer goroutine never blocks on it.
+	C() SyncChannel
+	// Stop prevents the timer from firing. Stop returns true if the call
+	// stops the timer, or false if the signal has already been received or
+	// the timer has already been stopped. Once Stop returns, C will not be
+	// signaled until the timer is reset.
+	Stop() bool
+	// Reset changes the timer to fire after the specified duration,
+	// discarding any signal that has not yet been received. Reset returns
+	// true if the timer was active.
+	Reset(d time.Duration) bool
+}
+
+type timer struct {
+	ch SyncChannel
+	when time.Time
+	index int
+
+	// fn, if not nil, is called in its own goroutine instead of signaling
+	// ch when the timer fires
+	fn func()
+}
+
+type timerOp struct {
+	t *timer
+	when time.Time
+	ret chan bool
+}
+
+type timerHeap []*timer
+
+// timers is the shared timer service
+var timers = newTimerService()
+
+type timerService struct {
+	ops chan *timerOp
+}
+
+// NewTimer returns a new Timer that will fire after the specified duration.
+func NewTimer(d time.Duration) Timer {
+	t := &timer{
+		ch: NewSyncChannelN(1),
+		index: -1,
+	}
+	timers.ops <- &timerOp{t: t, when: time.Now().Add(d)}
+	return t
+}
+
+// afterFunc returns a timer that calls f in its own goroutine after the
+// specified duration, unless it is stopped first.
+func afterFunc(d time.Duration, f func()) *timer {
+	t := &timer{
+		ch: NewSyncChannelN(1),
+		index: -1,
+		fn: f,
+	}
+	timers.ops <- &timerOp{t: t, when: time.Now().Add(d)}
+	return t
+}
+
+func (t *timer) C() SyncChannel {
+	return t.ch
+}
+
+func (t *timer) Stop() bool {
+	return timers.do(t, time.Time{})
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	return timers.do(t, time.Now().Add(d))
+}
+
+func newTimerService() *timerService {
+	s := &timerService{
+		ops: make(chan *timerOp),
+	}
+
+	go s.run()
+
+	return s
+}
+
+// do reschedules t to fire at when, or stops it if when is zero, and returns
+// whether t was active.
+func (s *timerService) do(t *timer, when time.Time) bool {
+	ret := make(chan bool)
+	s.ops <- &timerOp{t: t, when: when, ret: ret}
+	return <- ret
+}
+
+func (s *timerService) run() {
+	var h timerHeap
+
+	// wake is the only runtime timer the service uses; it is always reset
+	// to the earliest deadline
+	wake := time.NewTimer(time.Hour)
+	wake.Stop()
+
+	for {
+		var fire <-chan time.Time
+		if len(h) > 0 {
+			if !wake.Stop() {
+				select {
+				case <- wake.C:
+				default:
+				}
+			}
+			wake.Reset(time.Until(h[0].when))
+			fire = wake.C
+		}
+
+		select {
+		case op := <- s.ops:
+			active := op.t.index >= 0
+			if active {
+				heap.Remove(&h, op.t.index)
+			}
+
+			// a signal that has not been received is discarded, and the
+			// timer counts as active
+			if op.t.ch.TryRecv() == ChannelOpSuccess {
+				active = true
+			}
+
+			if !op.when.IsZero() {
+				op.t.when = op.when
+				heap.Push(&h, op.t)
+			}
+
+			if op.ret != nil {
+				op.ret <- active
+			}
+
+		case <- fire:
+			now := time.Now()
+			for len(h) > 0 && !h[0].when.After(now) {
+				t := heap.Pop(&h).(*timer)
+				if t.fn != nil {
+					go t.fn()
+				} else {
+					t.ch.TrySend()
+				}
+			}
+		}
+	}
+}
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x any) {
+	t := x.(*timer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *timerHeap) Pop() any {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
@@ -0,0 +1,141 @@
+package chansync
+
+import (
+	"container/heap"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestTimerFires(t *testing.T) {
+	tm := NewTimer(10 * time.Millisecond)
+	if r := tm.C().TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("the timer did not fire: %v", r)
+	}
+	if tm.Stop() {
+		t.Error("Stop returned true after the signal was received")
+	}
+}
+
+func TestTimerStopBeforeFiring(t *testing.T) {
+	tm := NewTimer(20 * time.Millisecond)
+	if !tm.Stop() {
+		t.Fatal("Stop returned false for an active timer")
+	}
+	if tm.Stop() {
+		t.Error("Stop returned true for a stopped timer")
+	}
+	if r := tm.C().TimeoutRecv(50 * time.Millisecond); r != ChannelOpTimeout {
+		t.Errorf("a stopped timer fired: %v", r)
+	}
+}
+
+// firedUnreceived returns a timer that has fired without its signal being
+// received. Timers that are due are fired in order, so once a later timer is
+// received, the earlier one has fired.
+func firedUnreceived(t *testing.T) Timer {
+	t.Helper()
+
+	tm := NewTimer(5 * time.Millisecond)
+	if r := NewTimer(10 * time.Millisecond).C().TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("the later timer did not fire: %v", r)
+	}
+	return tm
+}
+
+func TestTimerStopDiscardsSignal(t *testing.T) {
+	tm := firedUnreceived(t)
+	if !tm.Stop() {
+		t.Error("Stop returned false for a timer whose signal was not received")
+	}
+	if r := tm.C().TryRecv(); r != ChannelOpFailure {
+		t.Errorf("the signal survived Stop: %v", r)
+	}
+}
+
+func TestTimerResetDrains(t *testing.T) {
+	tm := firedUnreceived(t)
+	if !tm.Reset(time.Hour) {
+		t.Error("Reset returned false for a timer whose signal was not received")
+	}
+	if r := tm.C().TryRecv(); r != ChannelOpFailure {
+		t.Errorf("the signal survived Reset: %v", r)
+	}
+	tm.Stop()
+}
+
+func TestTimerResetAfterFiring(t *testing.T) {
+	tm := NewTimer(time.Millisecond)
+	tm.C().Recv()
+
+	if tm.Reset(10 * time.Millisecond) {
+		t.Error("Reset returned true for a timer that already fired")
+	}
+	if r := tm.C().TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("the reset timer did not fire: %v", r)
+	}
+}
+
+func TestTimerResetActive(t *testing.T) {
+	tm := NewTimer(time.Hour)
+	if !tm.Reset(10 * time.Millisecond) {
+		t.Error("Reset returned false for an active timer")
+	}
+	if r := tm.C().TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("the reset timer did not fire: %v", r)
+	}
+}
+
+func TestAfterFunc(t *testing.T) {
+	called := NewSyncChannelN(1)
+	afterFunc(10 * time.Millisecond, func() { called.Send() })
+	if r := called.TimeoutRecv(5 * time.Second); r != ChannelOpSuccess {
+		t.Fatalf("f was not called: %v", r)
+	}
+
+	stopped := NewSyncChannelN(1)
+	tm := afterFunc(10 * time.Millisecond, func() { stopped.Send() })
+	if !tm.Stop() {
+		t.Fatal("Stop returned false before f was called")
+	}
+	if r := stopped.TimeoutRecv(50 * time.Millisecond); r != ChannelOpTimeout {
+		t.Error("f was called after Stop")
+	}
+}
+
+func TestTimerHeap(t *testing.T) {
+	var h timerHeap
+	base := time.Now()
+	for _, i := range rand.Perm(100) {
+		heap.Push(&h, &timer{when: base.Add(time.Duration(i))})
+	}
+	for i, x := range h {
+		if x.index != i {
+			t.Fatalf("h[%d].index = %d", i, x.index)
+		}
+	}
+
+	// remove every third timer by its index, as Stop does
+	removed := map[*timer]bool{}
+	for i := 0; i < len(h); i += 3 {
+		removed[h[i]] = true
+	}
+	for x := range removed {
+		heap.Remove(&h, x.index)
+		if x.index != -1 {
+			t.Errorf("a removed timer has index %d", x.index)
+		}
+	}
+
+	var last time.Time
+	for h.Len() > 0 {
+		x := heap.Pop(&h).(*timer)
+		if removed[x] {
+			t.Fatal("a removed timer was popped")
+		}
+		if x.when.Before(last) {
+			t.Fatal("timers were popped out of order")
+		}
+		last = x.when
+	}
+}
@@ -0,0 +1,213 @@
+package chansync
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Diagnostics observe Lock, ReadWriteLock and Semaphore through wait and hold
+// records. A wait record exists while a goroutine is acquiring a primitive,
+// and a hold record exists while an acquisition is held. Records are only
+// created while at least one diagnostic is enabled, so primitives pay a few
+// atomic loads otherwise.
+
+// site describes the acquisition of a primitive.
+type site struct {
+	prim any
+	name string
+	class *LockClass
+
+	// gid and stack are recorded for the deadlock detector and the lock
+	// order validator
+	gid uint64
+	stack []byte
+
+	// pcs is recorded for the contention profile
+	pcs []uintptr
+}
+
+type waitRecord struct {
+	site
+
+	// blocked is when the acquisition had to start waiting, if it did
+	blocked time.Time
+}
+
+type holdRecord struct {
+	site
+
+	// start is when the acquisition was made, if it is being profiled
+	start time.Time
+	// detected is whether or not the deadlock detector knows of the hold
+	detected bool
+	// validated is whether or not the lock order validator knows of the hold
+	validated bool
+}
+
+// tracking returns whether or not any diagnostic is enabled.
+func tracking() bool {
+	return tracingGoroutines() || profiling.Load()
+}
+
+// tracingGoroutines returns whether or not any diagnostic that follows
+// acquisitions by goroutine is enabled.
+func tracingGoroutines() bool {
+	return deadlockHandler.Load() != nil || lockOrderHandler.Load() != nil
+}
+
+// newSite describes an acquisition of prim, which is a primitive of the
+// specified kind, made by the caller of beginWait or beginHold.
+func newSite(prim any, kind string) site {
+	name := primName(prim, kind)
+	s := site{prim: prim, name: name, class: classOf(prim, name)}
+	if tracingGoroutines() {
+		s.gid, s.stack = callerStack()
+	}
+	if profiling.Load() {
+		// skip runtime.Callers, callerPCs, newSite, and beginWait or
+		// beginHold
+		s.pcs = callerPCs(4)
+	}
+	return s
+}
+
+// beginWait records that the calling goroutine is about to acquire prim,
+// which is a primitive of the specified kind. beginWait returns nil if no
+// diagnostic is enabled.
+func beginWait(prim any, kind string) *waitRecord {
+	if !tracking() {
+		return nil
+	}
+
+	w := &waitRecord{site: newSite(prim, kind)}
+	if w.stack != nil {
+		if lockOrderHandler.Load() != nil {
+			validator.acquire(w)
+		}
+		detector.wait(w)
+	}
+	return w
+}
+
+// block records that the acquisition could not be made immediately, and has
+// to wait. block may be called on nil.
+func (w *waitRecord) block() {
+	if w == nil || w.pcs == nil {
+		return
+	}
+	w.blocked = time.Now()
+}
+
+// end records that the wait is over. If the primitive was acquired, end
+// returns a hold record for the acquisition. end may be called on nil.
+func (w *waitRecord) end(acquired bool) *holdRecord {
+	if w == nil {
+		return nil
+	}
+
+	if w.stack != nil {
+		detector.unwait(w)
+	}
+	if !w.blocked.IsZero() {
+		contention.wait(w)
+	}
+	if !acquired {
+		return nil
+	}
+
+	h := &holdRecord{site: w.site}
+	h.begin()
+	return h
+}
+
+// beginHold records an acquisition of prim, which is a primitive of the
+// specified kind, that did not have to wait. beginHold returns nil if no
+// diagnostic is enabled.
+func beginHold(prim any, kind string) *holdRecord {
+	if !tracking() {
+		return nil
+	}
+
+	h := &holdRecord{site: newSite(prim, kind)}
+	h.begin()
+	return h
+}
+
+func (h *holdRecord) begin() {
+	if h.stack != nil && deadlockHandler.Load() != nil {
+		detector.hold(h)
+		h.detected = true
+	}
+	if h.stack != nil && lockOrderHandler.Load() != nil {
+		validator.hold(h)
+		h.validated = true
+	}
+	if h.pcs != nil {
+		contention.acquire(h)
+		h.start = time.Now()
+	}
+}
+
+// end records that the acquisition has been released. end may be called on
+// nil.
+func (h *holdRecord) end() {
+	if h == nil {
+		return
+	}
+
+	if h.detected {
+		detector.unhold(h)
+	}
+	if h.validated {
+		validator.unhold(h)
+	}
+	if h.pcs != nil {
+		contention.hold(h)
+	}
+}
+
+// primName returns a name that identifies prim in reports.
+func primName(prim any, kind string) string {
+	return fmt.Sprintf("%s(%p)", kind, prim)
+}
+
+// callerStack returns the ID and stack trace of the calling goroutine.
+func callerStack() (uint64, []byte) {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// the first line is "goroutine N [running]:"
+	line := buf
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	fields := bytes.Fields(line)
+	if len(fields) < 2 {
+		return 0, buf
+	}
+	gid, _ := strconv.ParseUint(string(fields[1]), 10, 64)
+	return gid, buf
+}
+
+// callerPCs returns the program counters of the calling goroutine's stack,
+// skipping the specified number of frames as runtime.Callers does.
+func callerPCs(skip int) []uintptr {
+	pcs := make([]uintptr, 32)
+	for {
+		n := runtime.Callers(skip, pcs)
+		if n < len(pcs) {
+			return pcs[:n]
+		}
+		pcs = make([]uintptr, 2*len(pcs))
+	}
+}
@@ -0,0 +1,184 @@
+package chansync
+
+import "context"
+
+// UpgradableUnlock represents an upgradable read lock acquired from an
+// instance of ReadWriteLock. An upgradable read lock can be released, upgraded
+// to the write lock, or downgraded to a plain read lock. Only one upgradable
+// read lock of a ReadWriteLock can be held at a time, so unlike
+// ReadUnlock.Promote, an upgrade never conflicts with another upgrade: a
+// pending promotion of a plain read lock gives way to it and fails instead.
+type UpgradableUnlock interface {
+	// Release releases the upgradable read lock.
+	Release()
+
+	// Upgrade blocks until the upgradable read lock can be upgraded into the
+	// write lock, which happens once every other read lock is released.
+	// Upgrade returns a WriteUnlock associated with the upgraded lock. If the
+	// lock does not support upgrades, as with a ReadWriteLock returned by
+	// FromRWMutex, Upgrade fails immediately and returns nil, and the
+	// upgradable read lock remains held. Callers of Upgrade on such locks
+	// must check for nil; UpgradeContext reports the failure as
+	// ErrUpgradeUnsupported.
+	Upgrade() WriteUnlock
+
+	// UpgradeContext blocks until the upgradable read lock can be upgraded
+	// into the write lock or ctx is done. If ctx is done first,
+	// UpgradeContext returns (nil, ctx.Err()) and the upgradable read lock
+	// remains held. If the lock does not support upgrades, UpgradeContext
+	// returns (nil, ErrUpgradeUnsupported) immediately, and the upgradable
+	// read lock remains held. Otherwise UpgradeContext returns a
+	// (WriteUnlock, nil) pair.
+	UpgradeContext(ctx context.Context) (WriteUnlock, error)
+
+	// TryUpgrade attempts to upgrade the upgradable read lock into the write
+	// lock. If the lock cannot be upgraded, including when it does not
+	// support upgrades, TryUpgrade returns (nil, false) and the upgradable
+	// read lock remains held. Otherwise TryUpgrade returns
+	// a (WriteUnlock, true) pair.
+	TryUpgrade() (WriteUnlock, bool)
+
+	// Downgrade downgrades the upgradable read lock into a plain read lock,
+	// letting another upgradable read lock be acquired. Downgrade always
+	// returns immediately.
+	Downgrade() ReadUnlock
+}
+
+type uunlock struct {
+	token
+	lock *rwlock
+	hold *holdRecord
+}
+
+func (l *rwlock) AcquireUpgradable() UpgradableUnlock {
+	u, _ := l.AcquireUpgradableContext(context.Background())
+	return u
+}
+
+func (l *rwlock) AcquireUpgradableContext(ctx context.Context) (UpgradableUnlock, error) {
+	w := beginWait(l, "ReadWriteLock")
+	err := l.acquireUpgradable(ctx, w)
+	h := w.end(err == nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// create the upgradable unlock
+	return l.newUpgradableUnlock(h), nil
+}
+
+func (l *rwlock) acquireUpgradable(ctx context.Context, w *waitRecord) error {
+	l.mu.lock()
+	if l.tryUpgradable() {
+		l.mu.unlock()
+		return nil
+	}
+	w.block()
+	l.waitingReaders++
+	l.mu.unlock()
+
+	// the phases of RWPhaseFair do not apply, since the reader may have to
+	// wait for another upgradable read lock rather than for a writer
+	return l.awaitReader(ctx, l.tryUpgradable)
+}
+
+// tryUpgradable acquires the upgradable read lock if it is free and the
+// policy lets a new reader in. The caller must hold l.mu.
+func (l *rwlock) tryUpgradable() bool {
+	if l.upgradable || !l.readable() {
+		return false
+	}
+	l.readers++
+	l.upgradable = true
+	l.acquisitions++
+	return true
+}
+
+func (l *rwlock) TryAcquireUpgradable() (UpgradableUnlock, bool) {
+	l.mu.lock()
+	ok := l.tryUpgradable()
+	l.mu.unlock()
+	if !ok {
+		return nil, false
+	}
+
+	// create the upgradable unlock
+	return l.newUpgradableUnlock(beginHold(l, "ReadWriteLock")), true
+}
+
+func (l *rwlock) newUpgradableUnlock(h *holdRecord) UpgradableUnlock {
+	return &uunlock{
+		lock: l,
+		hold: h,
+	}
+}
+
+func (u *uunlock) Release() {
+	if u.consume("UpgradableUnlock", useRelease) != nil {
+		return
+	}
+
+	// release this upgradable read lock
+	u.hold.end()
+	l := u.lock
+	l.mu.lock()
+	l.readers--
+	l.upgradable = false
+	l.wake()
+	l.mu.unlock()
+}
+
+func (u *uunlock) Upgrade() WriteUnlock {
+	w, _ := u.UpgradeContext(context.Background())
+	return w
+}
+
+func (u *uunlock) UpgradeContext(ctx context.Context) (WriteUnlock, error) {
+	if err := u.consume("UpgradableUnlock", useUpgrade); err != nil {
+		return nil, err
+	}
+
+	w, h, err := u.lock.upgrade(ctx, u.hold, claimUpgrade)
+	if err != nil {
+		u.hold = h
+		u.restore()
+		return nil, err
+	}
+	u.lock.releaseUpgradable()
+	return w, nil
+}
+
+func (u *uunlock) TryUpgrade() (WriteUnlock, bool) {
+	if u.consume("UpgradableUnlock", useTryUpgrade) != nil {
+		return nil, false
+	}
+
+	w, ok := u.lock.tryUpgrade(u.hold, claimUpgrade)
+	if !ok {
+		u.restore()
+		return nil, false
+	}
+	u.lock.releaseUpgradable()
+	return w, true
+}
+
+// releaseUpgradable lets another upgradable read lock be acquired once the
+// upgradable read lock has been traded for another lock.
+func (l *rwlock) releaseUpgradable() {
+	l.mu.lock()
+	defer l.mu.unlock()
+
+	l.upgradable = false
+	l.wake()
+}
+
+func (u *uunlock) Downgrade() ReadUnlock {
+	if u.consume("UpgradableUnlock", useDowngrade) != nil {
+		return nil
+	}
+
+	// the read lock is kept, along with its hold record
+	l := u.lock
+	l.releaseUpgradable()
+	return l.newReadUnlock(u.hold)
+}